@@ -0,0 +1,466 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/YoavIsaacs/chirpy/internal/auth"
+	"github.com/YoavIsaacs/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+// serveAuthenticated runs handler behind middlewareAuthenticate as userID.
+func serveAuthenticated(t *testing.T, cfg *apiConfig, handler http.HandlerFunc, userID uuid.UUID, req *http.Request) *httptest.ResponseRecorder {
+	t.Helper()
+	token, err := auth.MakeJWT(userID, cfg.config.JWTSecret, time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to create token: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	cfg.middlewareAuthenticate(handler)(rec, req)
+	return rec
+}
+
+func TestAddChirpAuthentication(t *testing.T) {
+	authorID := uuid.New()
+
+	db := newFakeDB(t)
+	var author any
+	db.on("CreateChirp", func(args []driver.NamedValue) fakeResult {
+		author = args[1].Value
+		return chirpRow(database.Chirp{ID: uuid.New(), Body: args[0].Value.(string), UserID: authorID})(args)
+	})
+	cfg := newTestConfig(db)
+	handler := cfg.middlewareAuthenticate(cfg.addChirpsHandler)
+
+	token := func(secret string, expiresIn time.Duration) string {
+		token, err := auth.MakeJWT(authorID, secret, expiresIn)
+		if err != nil {
+			t.Fatalf("Failed to create token: %v", err)
+		}
+		return token
+	}
+
+	tests := []struct {
+		name          string
+		authorization string
+	}{
+		{name: "Missing header", authorization: ""},
+		{name: "Not a bearer token", authorization: "Basic " + token(cfg.config.JWTSecret, time.Minute)},
+		{name: "Malformed token", authorization: "Bearer not-a-jwt"},
+		{name: "Expired token", authorization: "Bearer " + token(cfg.config.JWTSecret, -time.Minute)},
+		{name: "Wrong signature", authorization: "Bearer " + token("some-other-secret", time.Minute)},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/chirps", strings.NewReader(`{"body":"hello"}`))
+			if tc.authorization != "" {
+				req.Header.Set("Authorization", tc.authorization)
+			}
+			rec := httptest.NewRecorder()
+			handler(rec, req)
+			if rec.Code != http.StatusUnauthorized {
+				t.Fatalf("Expected 401, got %d: %s", rec.Code, rec.Body.String())
+			}
+		})
+	}
+	if author != nil {
+		t.Fatalf("Expected no chirp to be created, got one by %v", author)
+	}
+
+	t.Run("Author comes from the token", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/api/chirps", strings.NewReader(`{"body":"hello","user_id":"`+uuid.NewString()+`"}`))
+		rec := serveAuthenticated(t, cfg, cfg.addChirpsHandler, authorID, req)
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("Expected 201, got %d: %s", rec.Code, rec.Body.String())
+		}
+		if author != authorID.String() {
+			t.Fatalf("Expected the chirp to be stored as %v, got %v", authorID, author)
+		}
+	})
+}
+
+func TestAddChirpLength(t *testing.T) {
+	userID := uuid.New()
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantStored string
+	}{
+		{name: "Fifty emoji", body: strings.Repeat("\U0001F600", 50), wantStatus: http.StatusCreated, wantStored: strings.Repeat("\U0001F600", 50)},
+		{name: "Combining marks count once", body: strings.Repeat("e\u0301", 140), wantStatus: http.StatusCreated, wantStored: strings.Repeat("é", 140)},
+		{name: "Long link counts as 23", body: "read https://example.com/" + strings.Repeat("a", 150), wantStatus: http.StatusCreated, wantStored: "read https://example.com/" + strings.Repeat("a", 150)},
+		{name: "Trimmed and stripped", body: "  hi\u200b there\x00 \n", wantStatus: http.StatusCreated, wantStored: "hi there"},
+		{name: "Too many characters", body: strings.Repeat("x", 141), wantStatus: http.StatusUnprocessableEntity},
+		{name: "Only invisible characters", body: " \u200b\u2060 ", wantStatus: http.StatusUnprocessableEntity},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			db := newFakeDB(t)
+			var stored string
+			db.on("CreateChirp", func(args []driver.NamedValue) fakeResult {
+				stored = args[0].Value.(string)
+				return chirpRow(database.Chirp{ID: uuid.New(), Body: stored, UserID: userID})(args)
+			})
+			cfg := newTestConfig(db)
+
+			payload, err := json.Marshal(map[string]string{"body": tc.body})
+			if err != nil {
+				t.Fatalf("Failed to encode body: %v", err)
+			}
+			req := httptest.NewRequest(http.MethodPost, "/api/chirps", strings.NewReader(string(payload)))
+			rec := serveAuthenticated(t, cfg, cfg.addChirpsHandler, userID, req)
+			if rec.Code != tc.wantStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tc.wantStatus, rec.Code, rec.Body.String())
+			}
+			if stored != tc.wantStored {
+				t.Fatalf("Expected %q to be stored, got %q", tc.wantStored, stored)
+			}
+		})
+	}
+}
+
+func TestLimits(t *testing.T) {
+	cfg := newTestConfig(newFakeDB(t))
+
+	rec := httptest.NewRecorder()
+	cfg.limitsHandler(rec, httptest.NewRequest(http.MethodGet, "/api/limits", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+	want := `{"chirp":{"max_length":140,"url_length":23,"length_unit":"grapheme","normalization":"NFC"}}`
+	if got := strings.TrimSpace(rec.Body.String()); got != want {
+		t.Fatalf("Expected %s, got %s", want, got)
+	}
+}
+
+func TestEditChirp(t *testing.T) {
+	authorID := uuid.New()
+	postedAt := time.Now().UTC().Add(-5 * time.Minute)
+	original := database.Chirp{ID: uuid.New(), CreatedAt: postedAt, UpdatedAt: postedAt, Body: "I am the one who knocks", UserID: authorID}
+
+	tests := []struct {
+		name         string
+		userID       uuid.UUID
+		chirp        database.Chirp
+		body         string
+		conflict     bool
+		wantStatus   int
+		wantRevision bool
+	}{
+		{name: "Author within window", userID: authorID, chirp: original, body: "I am the one who knocks!", wantStatus: http.StatusOK, wantRevision: true},
+		{name: "Unchanged body", userID: authorID, chirp: original, body: "  I am the one who knocks ", wantStatus: http.StatusOK},
+		{name: "Not the author", userID: uuid.New(), chirp: original, body: "mine now", wantStatus: http.StatusForbidden},
+		{name: "Window closed", userID: authorID, chirp: database.Chirp{ID: original.ID, CreatedAt: postedAt.Add(-time.Hour), Body: original.Body, UserID: authorID}, body: "too late", wantStatus: http.StatusForbidden},
+		{name: "Same validation as new chirps", userID: authorID, chirp: original, body: strings.Repeat("x", 141), wantStatus: http.StatusUnprocessableEntity},
+		{name: "Empty body", userID: authorID, chirp: original, body: " ", wantStatus: http.StatusUnprocessableEntity},
+		{name: "Concurrent edit", userID: authorID, chirp: original, body: "second writer", conflict: true, wantStatus: http.StatusConflict},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			db := newFakeDB(t)
+			db.on("GetSingleChirp", chirpRow(tc.chirp))
+			db.on("UpdateChirpBody", func(args []driver.NamedValue) fakeResult {
+				if tc.conflict {
+					return fakeResult{Columns: chirpColumns}
+				}
+				updated := tc.chirp
+				updated.Body = args[1].Value.(string)
+				updated.UpdatedAt = time.Now().UTC()
+				updated.RevisionCount = int32(args[2].Value.(int64)) + 1
+				return chirpRow(updated)(args)
+			})
+			var revision []driver.NamedValue
+			db.on("CreateChirpRevision", func(args []driver.NamedValue) fakeResult {
+				revision = args
+				return fakeResult{}
+			})
+			cfg := newTestConfig(db)
+
+			req := httptest.NewRequest(http.MethodPut, "/api/chirps/"+tc.chirp.ID.String(), strings.NewReader(`{"body":"`+tc.body+`"}`))
+			req.SetPathValue("chirpID", tc.chirp.ID.String())
+			rec := serveAuthenticated(t, cfg, cfg.editChirpHandler, tc.userID, req)
+			if rec.Code != tc.wantStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tc.wantStatus, rec.Code, rec.Body.String())
+			}
+
+			if !tc.wantRevision {
+				if revision != nil {
+					t.Fatal("Expected no revision to be stored")
+				}
+				return
+			}
+			resp := chirpResponse{}
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("Expected JSON body, got %v", err)
+			}
+			if resp.Body != tc.body || !resp.Edited || resp.RevisionCount != 1 {
+				t.Fatalf("Expected an edited chirp with one revision, got %+v", resp)
+			}
+			if revision[1].Value != int64(1) || revision[2].Value != original.Body || !revision[3].Value.(time.Time).Equal(original.UpdatedAt) {
+				t.Fatalf("Expected the original body stored as revision 1, got %v", revision)
+			}
+		})
+	}
+}
+
+func TestEditChirpDisabled(t *testing.T) {
+	authorID := uuid.New()
+	chirp := database.Chirp{ID: uuid.New(), CreatedAt: time.Now().UTC(), Body: "hello", UserID: authorID}
+
+	db := newFakeDB(t)
+	db.on("GetSingleChirp", chirpRow(chirp))
+	cfg := newTestConfig(db)
+	cfg.config.ChirpEditWindow = 0
+
+	req := httptest.NewRequest(http.MethodPut, "/api/chirps/"+chirp.ID.String(), strings.NewReader(`{"body":"hello there"}`))
+	req.SetPathValue("chirpID", chirp.ID.String())
+	rec := serveAuthenticated(t, cfg, cfg.editChirpHandler, authorID, req)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("Expected 403 with editing disabled, got %d", rec.Code)
+	}
+}
+
+func TestGetChirpRevisions(t *testing.T) {
+	postedAt := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	chirp := database.Chirp{ID: uuid.New(), CreatedAt: postedAt, UpdatedAt: postedAt.Add(2 * time.Minute), Body: "third", UserID: uuid.New(), RevisionCount: 2}
+
+	db := newFakeDB(t)
+	db.on("GetSingleChirp", func(args []driver.NamedValue) fakeResult {
+		if args[0].Value != chirp.ID.String() {
+			return fakeResult{Columns: chirpColumns}
+		}
+		return chirpRow(chirp)(args)
+	})
+	db.on("ListChirpRevisions", func([]driver.NamedValue) fakeResult {
+		return fakeResult{
+			Columns: []string{"chirp_id", "revision", "body", "created_at"},
+			Rows: [][]driver.Value{
+				{chirp.ID.String(), int64(1), "first", postedAt},
+				{chirp.ID.String(), int64(2), "second", postedAt.Add(time.Minute)},
+			},
+		}
+	})
+	cfg := newTestConfig(db)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/chirps/{chirpID}/revisions", cfg.getChirpRevisionsHandler)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/chirps/"+chirp.ID.String()+"/revisions", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp struct {
+		Chirp     chirpResponse `json:"chirp"`
+		Revisions []struct {
+			Revision int32  `json:"revision"`
+			Body     string `json:"body"`
+		} `json:"revisions"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Expected JSON body, got %v", err)
+	}
+	if resp.Chirp.Body != "third" || !resp.Chirp.Edited || resp.Chirp.RevisionCount != 2 {
+		t.Fatalf("Expected the current chirp, got %+v", resp.Chirp)
+	}
+	if len(resp.Revisions) != 2 || resp.Revisions[0].Body != "first" || resp.Revisions[1].Revision != 2 {
+		t.Fatalf("Expected both earlier bodies oldest first, got %+v", resp.Revisions)
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/chirps/"+uuid.NewString()+"/revisions", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("Expected 404 for an unknown chirp, got %d", rec.Code)
+	}
+}
+
+func TestDeleteChirp(t *testing.T) {
+	ownerID := uuid.New()
+	chirp := database.Chirp{ID: uuid.New(), Body: "say my name", UserID: ownerID}
+
+	tests := []struct {
+		name          string
+		path          string
+		userID        uuid.UUID
+		authorization string
+		wantStatus    int
+		wantDeleted   bool
+	}{
+		{name: "Owner", path: "/api/chirps/" + chirp.ID.String(), userID: ownerID, wantStatus: http.StatusNoContent, wantDeleted: true},
+		{name: "Not the owner", path: "/api/chirps/" + chirp.ID.String(), userID: uuid.New(), wantStatus: http.StatusForbidden},
+		{name: "Missing chirp", path: "/api/chirps/" + uuid.NewString(), userID: ownerID, wantStatus: http.StatusNotFound},
+		{name: "Malformed ID", path: "/api/chirps/not-a-uuid", userID: ownerID, wantStatus: http.StatusBadRequest},
+		{name: "Admin deletes another user's chirp", path: "/admin/chirps/" + chirp.ID.String(), authorization: "ApiKey admin-key", wantStatus: http.StatusNoContent, wantDeleted: true},
+		{name: "Admin missing chirp", path: "/admin/chirps/" + uuid.NewString(), authorization: "ApiKey admin-key", wantStatus: http.StatusNotFound},
+		{name: "Wrong admin key", path: "/admin/chirps/" + chirp.ID.String(), authorization: "ApiKey guess", wantStatus: http.StatusUnauthorized},
+		{name: "User token on admin route", path: "/admin/chirps/" + chirp.ID.String(), userID: ownerID, wantStatus: http.StatusUnauthorized},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			db := newFakeDB(t)
+			db.on("GetSingleChirp", func(args []driver.NamedValue) fakeResult {
+				if args[0].Value != chirp.ID.String() {
+					return fakeResult{Columns: chirpColumns}
+				}
+				return chirpRow(chirp)(args)
+			})
+			var deleted any
+			db.on("DeleteChirp", func(args []driver.NamedValue) fakeResult {
+				deleted = args[0].Value
+				return fakeResult{}
+			})
+			cfg := newTestConfig(db)
+			cfg.config.AdminAPIKey = "admin-key"
+
+			mux := http.NewServeMux()
+			mux.HandleFunc("DELETE /api/chirps/{chirpID}", cfg.middlewareAuthenticate(cfg.deleteChirpHandler))
+			mux.HandleFunc("DELETE /admin/chirps/{chirpID}", cfg.middlewareAdmin(cfg.adminDeleteChirpHandler))
+
+			req := httptest.NewRequest(http.MethodDelete, tc.path, nil)
+			req.Header.Set("Authorization", tc.authorization)
+			if tc.authorization == "" {
+				token, err := auth.MakeJWT(tc.userID, cfg.config.JWTSecret, time.Minute)
+				if err != nil {
+					t.Fatalf("Failed to create token: %v", err)
+				}
+				req.Header.Set("Authorization", "Bearer "+token)
+			}
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+			if rec.Code != tc.wantStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tc.wantStatus, rec.Code, rec.Body.String())
+			}
+			if tc.wantDeleted != (deleted == chirp.ID.String()) {
+				t.Fatalf("Expected deleted to be %v, got %v", tc.wantDeleted, deleted)
+			}
+		})
+	}
+}
+
+func TestListChirps(t *testing.T) {
+	postedAt := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	chirps := make([]database.Chirp, 3)
+	for i := range chirps {
+		chirps[i] = database.Chirp{ID: uuid.New(), CreatedAt: postedAt.Add(time.Duration(i) * time.Minute), Body: "chirp", UserID: uuid.New()}
+	}
+
+	type listCall struct {
+		query    string
+		rowLimit any
+	}
+	newServer := func(t *testing.T, available int) (*apiConfig, *[]listCall) {
+		db := newFakeDB(t)
+		var calls []listCall
+		list := func(query string) fakeQueryFunc {
+			return func(args []driver.NamedValue) fakeResult {
+				calls = append(calls, listCall{query: query, rowLimit: args[5].Value})
+				rows := fakeResult{Columns: chirpColumns}
+				for _, chirp := range chirps[:min(available, int(args[5].Value.(int64)))] {
+					rows.Rows = append(rows.Rows, chirpRow(chirp)(args).Rows[0])
+				}
+				return rows
+			}
+		}
+		db.on("ListChirpsAsc", list("ListChirpsAsc"))
+		db.on("ListChirpsDesc", list("ListChirpsDesc"))
+		return newTestConfig(db), &calls
+	}
+	get := func(cfg *apiConfig, query string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		cfg.getAllChirpsHandler(rec, httptest.NewRequest(http.MethodGet, "/api/chirps?"+query, nil))
+		return rec
+	}
+
+	t.Run("Invalid parameters", func(t *testing.T) {
+		cfg, calls := newServer(t, 3)
+		for _, query := range []string{
+			"author_id=nope",
+			"since=yesterday",
+			"until=2025-01-01",
+			"sort=sideways",
+			"limit=abc",
+			"limit=0",
+			"limit=-5",
+			"cursor=***",
+		} {
+			if rec := get(cfg, query); rec.Code != http.StatusBadRequest {
+				t.Fatalf("Expected 400 for %s, got %d", query, rec.Code)
+			}
+		}
+		if len(*calls) != 0 {
+			t.Fatalf("Expected no query for invalid parameters, got %v", *calls)
+		}
+	})
+
+	t.Run("Sort and limit", func(t *testing.T) {
+		tests := []struct {
+			query        string
+			wantQuery    string
+			wantRowLimit int64
+		}{
+			{query: "", wantQuery: "ListChirpsAsc", wantRowLimit: defaultChirpPageSize + 1},
+			{query: "sort=asc&limit=10", wantQuery: "ListChirpsAsc", wantRowLimit: 11},
+			{query: "sort=desc&limit=100", wantQuery: "ListChirpsDesc", wantRowLimit: maxChirpPageSize + 1},
+			{query: "sort=desc&limit=5000", wantQuery: "ListChirpsDesc", wantRowLimit: maxChirpPageSize + 1},
+		}
+		for _, tc := range tests {
+			cfg, calls := newServer(t, 3)
+			if rec := get(cfg, tc.query); rec.Code != http.StatusOK {
+				t.Fatalf("Expected 200 for %q, got %d: %s", tc.query, rec.Code, rec.Body.String())
+			}
+			if len(*calls) != 1 || (*calls)[0].query != tc.wantQuery || (*calls)[0].rowLimit != tc.wantRowLimit {
+				t.Fatalf("Expected %s with row limit %d for %q, got %v", tc.wantQuery, tc.wantRowLimit, tc.query, *calls)
+			}
+		}
+	})
+
+	t.Run("Next cursor", func(t *testing.T) {
+		tests := []struct {
+			name       string
+			available  int
+			wantChirps int
+			wantCursor string
+		}{
+			{name: "Extra row returned", available: 3, wantChirps: 2, wantCursor: encodeChirpCursor(chirps[1])},
+			{name: "Exactly one page", available: 2, wantChirps: 2},
+			{name: "Short page", available: 1, wantChirps: 1},
+		}
+		for _, tc := range tests {
+			t.Run(tc.name, func(t *testing.T) {
+				cfg, _ := newServer(t, tc.available)
+				rec := get(cfg, "limit=2")
+				var page struct {
+					Chirps     []chirpResponse `json:"chirps"`
+					NextCursor *string         `json:"next_cursor"`
+				}
+				if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil {
+					t.Fatalf("Expected JSON body, got %v", err)
+				}
+				if len(page.Chirps) != tc.wantChirps {
+					t.Fatalf("Expected %d chirps, got %d", tc.wantChirps, len(page.Chirps))
+				}
+				if tc.wantCursor == "" {
+					if page.NextCursor != nil {
+						t.Fatalf("Expected no next_cursor, got %q", *page.NextCursor)
+					}
+					return
+				}
+				if page.NextCursor == nil || *page.NextCursor != tc.wantCursor {
+					t.Fatalf("Expected next_cursor %q, got %v", tc.wantCursor, page.NextCursor)
+				}
+			})
+		}
+	})
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/YoavIsaacs/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+// fakeResult is what a stubbed query answers with. Exec-style queries only
+// look at RowsAffected and Err.
+type fakeResult struct {
+	Columns      []string
+	Rows         [][]driver.Value
+	RowsAffected int64
+	Err          error
+}
+
+type fakeQueryFunc func(args []driver.NamedValue) fakeResult
+
+// fakeDB is a database/sql driver that dispatches on the sqlc query name so
+// handlers can be exercised without a running Postgres.
+type fakeDB struct {
+	t       *testing.T
+	mu      sync.Mutex
+	queries map[string]fakeQueryFunc
+	calls   []string
+
+	// connectErr, when set, makes every new connection fail as if the
+	// database were unreachable.
+	connectErr error
+}
+
+func newFakeDB(t *testing.T) *fakeDB {
+	return &fakeDB{t: t, queries: map[string]fakeQueryFunc{}}
+}
+
+func (f *fakeDB) on(name string, fn fakeQueryFunc) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.queries[name] = fn
+}
+
+func (f *fakeDB) sqlDB() *sql.DB {
+	db := sql.OpenDB(f)
+	f.t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func (f *fakeDB) dispatch(query string, args []driver.NamedValue) fakeResult {
+	name := queryName(query)
+	f.mu.Lock()
+	fn, ok := f.queries[name]
+	f.calls = append(f.calls, name)
+	f.mu.Unlock()
+	if !ok {
+		f.t.Errorf("unexpected query %q", name)
+		return fakeResult{Err: fmt.Errorf("unexpected query %q", name)}
+	}
+	return fn(args)
+}
+
+func queryName(query string) string {
+	const prefix = "-- name: "
+	line, _, _ := strings.Cut(query, "\n")
+	if !strings.HasPrefix(line, prefix) {
+		return line
+	}
+	name, _, _ := strings.Cut(strings.TrimPrefix(line, prefix), " ")
+	return name
+}
+
+func (f *fakeDB) Connect(context.Context) (driver.Conn, error) { return f.Open("") }
+func (f *fakeDB) Driver() driver.Driver                        { return f }
+
+func (f *fakeDB) Open(string) (driver.Conn, error) {
+	if f.connectErr != nil {
+		return nil, f.connectErr
+	}
+	return &fakeConn{db: f}, nil
+}
+
+type fakeConn struct {
+	db *fakeDB
+}
+
+func (c *fakeConn) Prepare(string) (driver.Stmt, error) {
+	return nil, fmt.Errorf("fakeDB: prepared statements are not supported")
+}
+func (c *fakeConn) Close() error              { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error) { return fakeTx{db: c.db}, nil }
+
+func (c *fakeConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	result := c.db.dispatch(query, args)
+	if result.Err != nil {
+		return nil, result.Err
+	}
+	return &fakeRows{columns: result.Columns, rows: result.Rows}, nil
+}
+
+func (c *fakeConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	result := c.db.dispatch(query, args)
+	if result.Err != nil {
+		return nil, result.Err
+	}
+	return driver.RowsAffected(result.RowsAffected), nil
+}
+
+// fakeTx records the end of a transaction in calls as COMMIT or ROLLBACK.
+type fakeTx struct {
+	db *fakeDB
+}
+
+func (tx fakeTx) Commit() error   { return tx.record("COMMIT") }
+func (tx fakeTx) Rollback() error { return tx.record("ROLLBACK") }
+
+func (tx fakeTx) record(name string) error {
+	tx.db.mu.Lock()
+	defer tx.db.mu.Unlock()
+	tx.db.calls = append(tx.db.calls, name)
+	return nil
+}
+
+type fakeRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+var userColumns = []string{"id", "created_at", "updated_at", "email", "hashed_password", "is_chirpy_red", "verified_at"}
+
+func userRow(user database.User) fakeQueryFunc {
+	return func([]driver.NamedValue) fakeResult {
+		return fakeResult{
+			Columns: userColumns,
+			Rows: [][]driver.Value{{
+				user.ID.String(), user.CreatedAt, user.UpdatedAt, user.Email, user.HashedPassword, user.IsChirpyRed, nullTime(user.VerifiedAt),
+			}},
+		}
+	}
+}
+
+var chirpColumns = []string{"id", "created_at", "updated_at", "body", "user_id", "revision_count", "in_reply_to", "reply_count", "like_count"}
+
+func chirpRow(chirp database.Chirp) fakeQueryFunc {
+	return func([]driver.NamedValue) fakeResult {
+		return fakeResult{
+			Columns: chirpColumns,
+			Rows: [][]driver.Value{{
+				chirp.ID.String(), chirp.CreatedAt, chirp.UpdatedAt, chirp.Body, chirp.UserID.String(), int64(chirp.RevisionCount),
+				nullUUID(chirp.InReplyTo), int64(chirp.ReplyCount), int64(chirp.LikeCount),
+			}},
+		}
+	}
+}
+
+var loginFailureColumns = []string{"key", "failures", "last_failed_at"}
+
+func noLoginFailures([]driver.NamedValue) fakeResult {
+	return fakeResult{Columns: loginFailureColumns}
+}
+
+func nullTime(t sql.NullTime) driver.Value {
+	if !t.Valid {
+		return nil
+	}
+	return t.Time
+}
+
+func nullUUID(id uuid.NullUUID) driver.Value {
+	if !id.Valid {
+		return nil
+	}
+	return id.UUID.String()
+}
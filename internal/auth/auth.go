@@ -1,8 +1,14 @@
 package auth
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"net/http"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -27,13 +33,17 @@ func CheckPassword(hash, password string) error {
 }
 
 func MakeJWT(userID uuid.UUID, tokenSecret string, expiresIn time.Duration) (string, error) {
+	if tokenSecret == "" {
+		return "", errors.New("empty token secret")
+	}
+
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
 		Issuer:    "chirpy",
 		IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
 		ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
 		Subject:   userID.String(),
 	})
-	signedToken, err := token.SignedString(tokenSecret)
+	signedToken, err := token.SignedString([]byte(tokenSecret))
 	if err != nil {
 		return "", err
 	}
@@ -69,3 +79,81 @@ func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
 
 	return userID, nil
 }
+
+func MakeRefreshToken() (string, error) {
+	return randomToken("refresh")
+}
+
+// MakeOneTimeToken returns a random token to send to a user, e.g. for email
+// verification. Store only its HashToken digest.
+func MakeOneTimeToken() (string, error) {
+	return randomToken("one-time")
+}
+
+func randomToken(kind string) (string, error) {
+	tokenBytes := make([]byte, 32)
+	_, err := rand.Read(tokenBytes)
+	if err != nil {
+		return "", fmt.Errorf("error: error generating %s token: %s", kind, err)
+	}
+	return hex.EncodeToString(tokenBytes), nil
+}
+
+// HashToken digests a high-entropy token for storage. A fast hash is enough
+// because the token cannot be guessed, unlike a password.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func GetBearerToken(headers http.Header) (string, error) {
+	authHeader := headers.Get("Authorization")
+	if authHeader == "" {
+		return "", errors.New("missing authorization header")
+	}
+
+	// Expect exactly "Bearer <token>"
+	scheme, token, found := strings.Cut(authHeader, " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return "", errors.New("malformed authorization header")
+	}
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return "", errors.New("missing bearer token")
+	}
+
+	return token, nil
+}
+
+func GetAPIKey(headers http.Header) (string, error) {
+	authHeader := headers.Get("Authorization")
+	if authHeader == "" {
+		return "", errors.New("missing authorization header")
+	}
+
+	// Expect exactly "ApiKey <key>"
+	scheme, key, found := strings.Cut(authHeader, " ")
+	if !found || !strings.EqualFold(scheme, "ApiKey") {
+		return "", errors.New("malformed authorization header")
+	}
+	key = strings.TrimSpace(key)
+	if key == "" {
+		return "", errors.New("missing api key")
+	}
+
+	return key, nil
+}
+
+func CheckAPIKey(headers http.Header, expected string) error {
+	if expected == "" {
+		return errors.New("api key not configured")
+	}
+	key, err := GetAPIKey(headers)
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare([]byte(key), []byte(expected)) != 1 {
+		return errors.New("invalid api key")
+	}
+	return nil
+}
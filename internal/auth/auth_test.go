@@ -1,6 +1,7 @@
 package auth
 
 import (
+	"net/http"
 	"testing"
 	"time"
 
@@ -153,3 +154,109 @@ func TestValidateJWT(t *testing.T) {
 		}
 	})
 }
+
+func TestGetBearerToken(t *testing.T) {
+	tests := []struct {
+		name      string
+		header    string
+		wantToken string
+		wantErr   bool
+	}{
+		{name: "Valid header", header: "Bearer abc.def.ghi", wantToken: "abc.def.ghi"},
+		{name: "Lowercase scheme", header: "bearer abc.def.ghi", wantToken: "abc.def.ghi"},
+		{name: "Missing header", header: "", wantErr: true},
+		{name: "Wrong scheme", header: "Basic dXNlcjpwYXNz", wantErr: true},
+		{name: "No token", header: "Bearer ", wantErr: true},
+		{name: "No separator", header: "Bearerabc", wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			headers := http.Header{}
+			if tc.header != "" {
+				headers.Set("Authorization", tc.header)
+			}
+
+			token, err := GetBearerToken(headers)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("Expected error, got token '%s'", token)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if token != tc.wantToken {
+				t.Fatalf("Expected token '%s', got '%s'", tc.wantToken, token)
+			}
+		})
+	}
+}
+
+func TestMakeRefreshToken(t *testing.T) {
+	token, err := MakeRefreshToken()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(token) != 64 {
+		t.Fatalf("Expected 64 character token, got %d characters", len(token))
+	}
+
+	other, err := MakeRefreshToken()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if token == other {
+		t.Fatal("Expected two refresh tokens to differ")
+	}
+}
+
+func TestHashToken(t *testing.T) {
+	token, err := MakeOneTimeToken()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	hash := HashToken(token)
+	if hash == token || len(hash) != 64 {
+		t.Fatalf("Expected a 64 character digest distinct from the token, got '%s'", hash)
+	}
+	if HashToken(token) != hash {
+		t.Fatal("Expected hashing to be deterministic")
+	}
+	if HashToken(token+"x") == hash {
+		t.Fatal("Expected different tokens to hash differently")
+	}
+}
+
+func TestCheckAPIKey(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   string
+		expected string
+		wantErr  bool
+	}{
+		{name: "Matching key", header: "ApiKey secret-key", expected: "secret-key"},
+		{name: "Wrong key", header: "ApiKey other-key", expected: "secret-key", wantErr: true},
+		{name: "Bearer scheme", header: "Bearer secret-key", expected: "secret-key", wantErr: true},
+		{name: "Missing header", header: "", expected: "secret-key", wantErr: true},
+		{name: "Unconfigured key", header: "ApiKey ", expected: "", wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			headers := http.Header{}
+			if tc.header != "" {
+				headers.Set("Authorization", tc.header)
+			}
+
+			err := CheckAPIKey(headers, tc.expected)
+			if tc.wantErr && err == nil {
+				t.Fatal("Expected error, got nil")
+			}
+			if !tc.wantErr && err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+		})
+	}
+}
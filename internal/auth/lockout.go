@@ -0,0 +1,37 @@
+package auth
+
+import (
+	"time"
+)
+
+// LockoutPolicy slows down repeated failed logins. The first FreeAttempts
+// failures cost nothing, after that each failure doubles the wait before the
+// next attempt, and reaching Threshold locks the key for LockoutDuration.
+type LockoutPolicy struct {
+	FreeAttempts    int
+	BaseDelay       time.Duration
+	MaxDelay        time.Duration
+	Threshold       int
+	LockoutDuration time.Duration
+}
+
+// Delay is how long to wait after the given number of consecutive failures.
+func (p LockoutPolicy) Delay(failures int) time.Duration {
+	if failures >= p.Threshold {
+		return p.LockoutDuration
+	}
+	if failures <= p.FreeAttempts {
+		return 0
+	}
+	delay := p.BaseDelay
+	for i := p.FreeAttempts + 1; i < failures && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, p.MaxDelay)
+}
+
+// RetryAfter reports how long a caller must still wait before it may try to
+// log in again, or zero if it may try now.
+func (p LockoutPolicy) RetryAfter(failures int, lastFailedAt, now time.Time) time.Duration {
+	return max(lastFailedAt.Add(p.Delay(failures)).Sub(now), 0)
+}
@@ -0,0 +1,55 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLockoutPolicyDelay(t *testing.T) {
+	policy := LockoutPolicy{
+		FreeAttempts:    3,
+		BaseDelay:       time.Second,
+		MaxDelay:        10 * time.Second,
+		Threshold:       10,
+		LockoutDuration: 15 * time.Minute,
+	}
+
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{3, 0},
+		{4, time.Second},
+		{5, 2 * time.Second},
+		{6, 4 * time.Second},
+		{7, 8 * time.Second},
+		{8, 10 * time.Second},
+		{9, 10 * time.Second},
+		{10, 15 * time.Minute},
+		{50, 15 * time.Minute},
+	}
+	for _, tc := range tests {
+		if got := policy.Delay(tc.failures); got != tc.want {
+			t.Errorf("Delay(%d) = %v, want %v", tc.failures, got, tc.want)
+		}
+	}
+}
+
+func TestLockoutPolicyRetryAfter(t *testing.T) {
+	policy := LockoutPolicy{FreeAttempts: 1, BaseDelay: 4 * time.Second, MaxDelay: time.Minute, Threshold: 5, LockoutDuration: time.Hour}
+	lastFailed := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	if got := policy.RetryAfter(1, lastFailed, lastFailed); got != 0 {
+		t.Fatalf("Expected free attempt to allow an immediate retry, got %v", got)
+	}
+	if got := policy.RetryAfter(2, lastFailed, lastFailed.Add(time.Second)); got != 3*time.Second {
+		t.Fatalf("Expected 3s left of the delay, got %v", got)
+	}
+	if got := policy.RetryAfter(2, lastFailed, lastFailed.Add(time.Minute)); got != 0 {
+		t.Fatalf("Expected elapsed delay to allow a retry, got %v", got)
+	}
+	if got := policy.RetryAfter(5, lastFailed, lastFailed.Add(time.Minute)); got != 59*time.Minute {
+		t.Fatalf("Expected the lockout to have 59m left, got %v", got)
+	}
+}
@@ -0,0 +1,100 @@
+package chirptext
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/rivo/uniseg"
+	"golang.org/x/text/unicode/norm"
+)
+
+// DefaultURLLength is how many characters a link counts for, whatever its
+// real length, so shorteners buy nothing and long links aren't punished.
+const DefaultURLLength = 23
+
+var (
+	ErrEmpty   = errors.New("chirp body cannot be empty")
+	ErrTooLong = errors.New("chirp is too long")
+)
+
+// Rules decide how long a chirp may be and how that length is measured.
+type Rules struct {
+	MaxLength int
+	URLLength int
+}
+
+var urlPattern = regexp.MustCompile(`(?i)\bhttps?://[^\s<>"]+`)
+
+// invisible are format characters that render as nothing but can hide text,
+// pad length or reorder what readers see. The zero-width joiner and
+// non-joiner are kept since emoji sequences and several scripts need them.
+var invisible = map[rune]bool{
+	'\u00ad': true, // soft hyphen
+	'\u180e': true, // Mongolian vowel separator
+	'\u200b': true, // zero-width space
+	'\u200e': true, // left-to-right mark
+	'\u200f': true, // right-to-left mark
+	'\u202a': true, // bidi embeddings and overrides
+	'\u202b': true,
+	'\u202c': true,
+	'\u202d': true,
+	'\u202e': true,
+	'\u2060': true, // word joiner
+	'\u2066': true, // bidi isolates
+	'\u2067': true,
+	'\u2068': true,
+	'\u2069': true,
+	'\ufeff': true, // zero-width no-break space
+}
+
+// Normalize returns body in the form chirps are stored and measured in:
+// control and invisible characters removed except newlines and tabs, NFC
+// composed and trimmed of surrounding whitespace.
+func Normalize(body string) string {
+	body = strings.ReplaceAll(body, "\r\n", "\n")
+	body = strings.Map(func(r rune) rune {
+		if r == '\n' || r == '\t' {
+			return r
+		}
+		if unicode.IsControl(r) || invisible[r] {
+			return -1
+		}
+		return r
+	}, body)
+	return strings.TrimSpace(norm.NFC.String(body))
+}
+
+// Length counts user-perceived characters (grapheme clusters) in body, with
+// every link counted as URLLength.
+func (r Rules) Length(body string) int {
+	length := 0
+	prev := 0
+	for _, loc := range urlPattern.FindAllStringIndex(body, -1) {
+		url := trimURL(body[loc[0]:loc[1]])
+		if strings.HasSuffix(url, "://") {
+			continue
+		}
+		length += uniseg.GraphemeClusterCount(body[prev:loc[0]]) + r.URLLength
+		prev = loc[0] + len(url)
+	}
+	return length + uniseg.GraphemeClusterCount(body[prev:])
+}
+
+// trimURL drops punctuation that usually ends the sentence around a link
+// rather than the link itself.
+func trimURL(url string) string {
+	return strings.TrimRight(url, ".,:;!?'\")]}")
+}
+
+// Check reports whether an already normalized body fits the rules.
+func (r Rules) Check(body string) error {
+	if body == "" {
+		return ErrEmpty
+	}
+	if r.Length(body) > r.MaxLength {
+		return ErrTooLong
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package chirptext
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{name: "Plain text", in: "hello world", want: "hello world"},
+		{name: "Surrounding whitespace", in: "  \n\thello  ", want: "hello"},
+		{name: "Inner newlines kept", in: "line one\r\nline two", want: "line one\nline two"},
+		{name: "Control characters", in: "be\x00ep\x07 \x1b[31mred", want: "beep [31mred"},
+		{name: "Zero-width and bidi", in: "sp\u200bam \u202eevil\u202c\ufeff", want: "spam evil"},
+		{name: "Emoji joiners kept", in: "\U0001F469\u200d\U0001F4BB", want: "\U0001F469\u200d\U0001F4BB"},
+		{name: "NFC composed", in: "cafe\u0301", want: "café"},
+		{name: "Only invisible", in: " \u200b\u2060 ", want: ""},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := Normalize(tc.in); got != tc.want {
+				t.Fatalf("Expected %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestLength(t *testing.T) {
+	rules := Rules{MaxLength: 140, URLLength: DefaultURLLength}
+
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{name: "ASCII", body: "hello", want: 5},
+		{name: "Accented", body: "café", want: 4},
+		{name: "Combining mark", body: "cafe\u0301", want: 4},
+		{name: "Emoji", body: strings.Repeat("\U0001F600", 50), want: 50},
+		{name: "Flag", body: "\U0001F1EE\U0001F1F1", want: 1},
+		{name: "ZWJ family", body: "\U0001F468\u200d\U0001F469\u200d\U0001F467", want: 1},
+		{name: "Skin tone", body: "\U0001F44B\U0001F3FD", want: 1},
+		{name: "Short link", body: "see https://go.dev", want: 4 + DefaultURLLength},
+		{name: "Long link", body: "https://example.com/" + strings.Repeat("a", 200), want: DefaultURLLength},
+		{name: "Trailing punctuation", body: "(https://example.com/x).", want: 2 + DefaultURLLength + 1},
+		{name: "Two links", body: "http://a.io http://b.io", want: 2*DefaultURLLength + 1},
+		{name: "Scheme only", body: "https://", want: 8},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := rules.Length(tc.body); got != tc.want {
+				t.Fatalf("Expected length %d, got %d", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	rules := Rules{MaxLength: 10, URLLength: 5}
+
+	if err := rules.Check(""); !errors.Is(err, ErrEmpty) {
+		t.Fatalf("Expected ErrEmpty, got %v", err)
+	}
+	if err := rules.Check(strings.Repeat("\U0001F600", 10)); err != nil {
+		t.Fatalf("Expected 10 emoji to fit, got %v", err)
+	}
+	if err := rules.Check(strings.Repeat("x", 11)); !errors.Is(err, ErrTooLong) {
+		t.Fatalf("Expected ErrTooLong, got %v", err)
+	}
+	if err := rules.Check("ok https://example.com/very/long/path"); err != nil {
+		t.Fatalf("Expected link to count as 5, got %v", err)
+	}
+}
@@ -0,0 +1,323 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/YoavIsaacs/chirpy/internal/chirptext"
+	"github.com/YoavIsaacs/chirpy/internal/moderation"
+	"github.com/YoavIsaacs/chirpy/internal/ratelimit"
+	"github.com/joho/godotenv"
+)
+
+type Config struct {
+	ListenAddr       string
+	DBURL            string
+	JWTSecret        string
+	AccessTokenTTL   time.Duration
+	RefreshTokenTTL  time.Duration
+	Platform         string
+	PolkaKey         string
+	AdminAPIKey      string
+	StaticDir        string
+	BannedWordsFile  string
+	ModerationPolicy moderation.Policy
+	MaxChirpLength   int
+	ChirpURLLength   int
+	ChirpEditWindow  time.Duration
+
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	ShutdownDelay     time.Duration
+	ShutdownTimeout   time.Duration
+
+	DBConnectTimeout time.Duration
+	ReadinessTimeout time.Duration
+	MigrateOnStart   bool
+
+	LogLevel slog.Level
+
+	RateLimits        map[string]ratelimit.Limit
+	RateLimitStore    string
+	TrustProxyHeaders bool
+
+	LoginLockoutThreshold   int
+	LoginIPLockoutThreshold int
+	LoginLockoutDuration    time.Duration
+
+	Mailer               string
+	MailFrom             string
+	MailLogFile          string
+	SMTPAddr             string
+	SMTPUsername         string
+	SMTPPassword         string
+	EmailVerificationTTL time.Duration
+	PasswordResetTTL     time.Duration
+}
+
+func (c Config) IsDev() bool {
+	return c.Platform == "dev"
+}
+
+// setting describes one configuration value. Every setting can come from the
+// environment (or .env); only non-secret settings get a command-line flag.
+type setting struct {
+	env      string
+	flag     string
+	fallback string
+	usage    string
+	isBool   bool
+}
+
+var settings = []setting{
+	{env: "LISTEN_ADDR", flag: "addr", fallback: ":8080", usage: "address to listen on"},
+	{env: "DB_URL", flag: "db-url", usage: "Postgres connection URL"},
+	{env: "JWT_SECRET", usage: "secret used to sign access tokens"},
+	{env: "ACCESS_TOKEN_TTL", flag: "access-token-ttl", fallback: "1h", usage: "lifetime of access tokens"},
+	{env: "REFRESH_TOKEN_TTL", flag: "refresh-token-ttl", fallback: "1440h", usage: "lifetime of refresh tokens"},
+	{env: "PLATFORM", flag: "platform", fallback: "prod", usage: "deployment platform (dev or prod)"},
+	{env: "POLKA_KEY", usage: "API key Polka uses to call the webhook"},
+	{env: "ADMIN_API_KEY", usage: "API key for /admin moderation routes"},
+	{env: "STATIC_DIR", flag: "static-dir", usage: "serve /app/ from this directory instead of the embedded site"},
+	{env: "BANNED_WORDS_FILE", flag: "banned-words-file", usage: "file with one banned word per line"},
+	{env: "MODERATION_POLICY", flag: "moderation-policy", fallback: "rewrite", usage: "rewrite or reject chirps with banned words"},
+	{env: "MAX_CHIRP_LENGTH", flag: "max-chirp-length", fallback: "140", usage: "maximum chirp length in user-perceived characters"},
+	{env: "CHIRP_URL_LENGTH", flag: "chirp-url-length", fallback: strconv.Itoa(chirptext.DefaultURLLength), usage: "characters every link in a chirp counts for"},
+	{env: "CHIRP_EDIT_WINDOW", flag: "chirp-edit-window", fallback: "15m", usage: "how long after posting authors may edit a chirp; 0 disables editing"},
+	{env: "READ_HEADER_TIMEOUT", flag: "read-header-timeout", fallback: "5s", usage: "time allowed to read request headers"},
+	{env: "READ_TIMEOUT", flag: "read-timeout", fallback: "15s", usage: "time allowed to read a whole request"},
+	{env: "WRITE_TIMEOUT", flag: "write-timeout", fallback: "15s", usage: "time allowed to write a response"},
+	{env: "IDLE_TIMEOUT", flag: "idle-timeout", fallback: "60s", usage: "how long idle keep-alive connections are kept"},
+	{env: "SHUTDOWN_DELAY", flag: "shutdown-delay", fallback: "0s", usage: "time to report unhealthy before draining starts"},
+	{env: "SHUTDOWN_TIMEOUT", flag: "shutdown-timeout", fallback: "20s", usage: "deadline for in-flight requests to finish on shutdown"},
+	{env: "DB_CONNECT_TIMEOUT", flag: "db-connect-timeout", fallback: "30s", usage: "how long startup waits for the database"},
+	{env: "READINESS_TIMEOUT", flag: "readiness-timeout", fallback: "2s", usage: "deadline for the readiness database ping"},
+	{env: "MIGRATE_ON_START", flag: "migrate-on-start", fallback: "false", usage: "apply pending migrations before serving", isBool: true},
+	{env: "RATE_LIMITS", flag: "rate-limits", fallback: "POST /api/login=5/1m,POST /api/users=10/1h,POST /api/refresh=30/1m,POST /api/chirps=30/1m,POST /api/password/forgot=5/1h,POST /api/users/verify/resend=3/1h", usage: "comma-separated <route pattern>=<requests>/<duration> limits; empty disables rate limiting"},
+	{env: "RATE_LIMIT_STORE", flag: "rate-limit-store", fallback: "memory", usage: "where rate limit buckets live (memory or postgres)"},
+	{env: "TRUST_PROXY_HEADERS", flag: "trust-proxy-headers", fallback: "false", usage: "take client IPs from X-Forwarded-For", isBool: true},
+	{env: "LOGIN_LOCKOUT_THRESHOLD", flag: "login-lockout-threshold", fallback: "10", usage: "failed logins that lock an account"},
+	{env: "LOGIN_IP_LOCKOUT_THRESHOLD", flag: "login-ip-lockout-threshold", fallback: "100", usage: "failed logins that lock a client IP"},
+	{env: "LOGIN_LOCKOUT_DURATION", flag: "login-lockout-duration", fallback: "15m", usage: "how long lockouts last and failed logins are remembered"},
+	{env: "MAILER", flag: "mailer", fallback: "log", usage: "how to send email: log writes messages to MAIL_LOG_FILE, smtp delivers them"},
+	{env: "MAIL_FROM", flag: "mail-from", fallback: "Chirpy <no-reply@localhost>", usage: "From address of outgoing email"},
+	{env: "MAIL_LOG_FILE", flag: "mail-log-file", usage: "file the log mailer appends to (default stdout)"},
+	{env: "SMTP_ADDR", flag: "smtp-addr", usage: "host:port of the SMTP relay"},
+	{env: "SMTP_USERNAME", flag: "smtp-username", usage: "SMTP username"},
+	{env: "SMTP_PASSWORD", usage: "SMTP password"},
+	{env: "EMAIL_VERIFICATION_TTL", flag: "email-verification-ttl", fallback: "24h", usage: "lifetime of email verification tokens"},
+	{env: "PASSWORD_RESET_TTL", flag: "password-reset-ttl", fallback: "1h", usage: "lifetime of password reset tokens"},
+	{env: "LOG_LEVEL", flag: "log-level", fallback: "info", usage: "minimum log level (debug, info, warn or error)"},
+}
+
+// Load builds a Config by layering, from lowest to highest precedence:
+// defaults, the optional .env file, the environment and command-line flags.
+// It reports malformed values; call Validate before serving traffic.
+func Load(args []string, lookupEnv func(string) (string, bool)) (Config, error) {
+	flags := flag.NewFlagSet("chirpy", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	envFile := flags.String("env-file", ".env", "optional file of KEY=value settings")
+	flagValues := map[string]func() string{}
+	for _, s := range settings {
+		switch {
+		case s.flag == "":
+		case s.isBool:
+			v := flags.Bool(s.flag, s.fallback == "true", s.usage)
+			flagValues[s.env] = func() string { return strconv.FormatBool(*v) }
+		default:
+			v := flags.String(s.flag, s.fallback, s.usage)
+			flagValues[s.env] = func() string { return *v }
+		}
+	}
+	if err := flags.Parse(args); err != nil {
+		return Config{}, fmt.Errorf("config: %s", err)
+	}
+
+	setFlags := map[string]bool{}
+	flags.Visit(func(f *flag.Flag) {
+		setFlags[f.Name] = true
+	})
+
+	fileValues, err := godotenv.Read(*envFile)
+	if err != nil {
+		// A missing default .env is fine; a missing explicit one is not
+		if !errors.Is(err, os.ErrNotExist) || setFlags["env-file"] {
+			return Config{}, fmt.Errorf("config: error reading %s: %s", *envFile, err)
+		}
+		fileValues = map[string]string{}
+	}
+
+	values := map[string]string{}
+	for _, s := range settings {
+		values[s.env] = s.fallback
+		if v, ok := fileValues[s.env]; ok {
+			values[s.env] = v
+		}
+		if v, ok := lookupEnv(s.env); ok {
+			values[s.env] = v
+		}
+		if s.flag != "" && setFlags[s.flag] {
+			values[s.env] = flagValues[s.env]()
+		}
+	}
+
+	return parse(values)
+}
+
+func parse(values map[string]string) (Config, error) {
+	var errs []error
+	cfg := Config{
+		ListenAddr:      values["LISTEN_ADDR"],
+		DBURL:           values["DB_URL"],
+		JWTSecret:       values["JWT_SECRET"],
+		Platform:        values["PLATFORM"],
+		PolkaKey:        values["POLKA_KEY"],
+		AdminAPIKey:     values["ADMIN_API_KEY"],
+		StaticDir:       values["STATIC_DIR"],
+		BannedWordsFile: values["BANNED_WORDS_FILE"],
+		Mailer:          values["MAILER"],
+		MailFrom:        values["MAIL_FROM"],
+		MailLogFile:     values["MAIL_LOG_FILE"],
+		SMTPAddr:        values["SMTP_ADDR"],
+		SMTPUsername:    values["SMTP_USERNAME"],
+		SMTPPassword:    values["SMTP_PASSWORD"],
+	}
+
+	parseDuration := func(key string, allowZero bool) time.Duration {
+		d, err := time.ParseDuration(values[key])
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: invalid duration %q", key, values[key]))
+			return 0
+		}
+		if d < 0 || (d == 0 && !allowZero) {
+			errs = append(errs, fmt.Errorf("%s: must be positive", key))
+		}
+		return d
+	}
+	cfg.AccessTokenTTL = parseDuration("ACCESS_TOKEN_TTL", false)
+	cfg.RefreshTokenTTL = parseDuration("REFRESH_TOKEN_TTL", false)
+	cfg.ReadHeaderTimeout = parseDuration("READ_HEADER_TIMEOUT", false)
+	cfg.ReadTimeout = parseDuration("READ_TIMEOUT", false)
+	cfg.WriteTimeout = parseDuration("WRITE_TIMEOUT", false)
+	cfg.IdleTimeout = parseDuration("IDLE_TIMEOUT", false)
+	cfg.ShutdownDelay = parseDuration("SHUTDOWN_DELAY", true)
+	cfg.ShutdownTimeout = parseDuration("SHUTDOWN_TIMEOUT", false)
+	cfg.DBConnectTimeout = parseDuration("DB_CONNECT_TIMEOUT", false)
+	cfg.ReadinessTimeout = parseDuration("READINESS_TIMEOUT", false)
+	cfg.LoginLockoutDuration = parseDuration("LOGIN_LOCKOUT_DURATION", false)
+	cfg.ChirpEditWindow = parseDuration("CHIRP_EDIT_WINDOW", true)
+	cfg.EmailVerificationTTL = parseDuration("EMAIL_VERIFICATION_TTL", false)
+	cfg.PasswordResetTTL = parseDuration("PASSWORD_RESET_TTL", false)
+
+	policy, err := moderation.ParsePolicy(values["MODERATION_POLICY"])
+	if err != nil {
+		errs = append(errs, fmt.Errorf("MODERATION_POLICY: %s", err))
+	}
+	cfg.ModerationPolicy = policy
+
+	parseInt := func(key string) int {
+		n, err := strconv.Atoi(values[key])
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: invalid integer %q", key, values[key]))
+		}
+		return n
+	}
+	cfg.MaxChirpLength = parseInt("MAX_CHIRP_LENGTH")
+	cfg.ChirpURLLength = parseInt("CHIRP_URL_LENGTH")
+	cfg.LoginLockoutThreshold = parseInt("LOGIN_LOCKOUT_THRESHOLD")
+	cfg.LoginIPLockoutThreshold = parseInt("LOGIN_IP_LOCKOUT_THRESHOLD")
+
+	migrateOnStart, err := strconv.ParseBool(values["MIGRATE_ON_START"])
+	if err != nil {
+		errs = append(errs, fmt.Errorf("MIGRATE_ON_START: invalid boolean %q", values["MIGRATE_ON_START"]))
+	}
+	cfg.MigrateOnStart = migrateOnStart
+
+	rateLimits, err := ratelimit.ParseRouteLimits(values["RATE_LIMITS"])
+	if err != nil {
+		errs = append(errs, fmt.Errorf("RATE_LIMITS: %s", err))
+	}
+	cfg.RateLimits = rateLimits
+
+	cfg.RateLimitStore = values["RATE_LIMIT_STORE"]
+	if cfg.RateLimitStore != "memory" && cfg.RateLimitStore != "postgres" {
+		errs = append(errs, fmt.Errorf("RATE_LIMIT_STORE: must be memory or postgres, got %q", cfg.RateLimitStore))
+	}
+
+	trustProxy, err := strconv.ParseBool(values["TRUST_PROXY_HEADERS"])
+	if err != nil {
+		errs = append(errs, fmt.Errorf("TRUST_PROXY_HEADERS: invalid boolean %q", values["TRUST_PROXY_HEADERS"]))
+	}
+	cfg.TrustProxyHeaders = trustProxy
+
+	err = cfg.LogLevel.UnmarshalText([]byte(values["LOG_LEVEL"]))
+	if err != nil {
+		errs = append(errs, fmt.Errorf("LOG_LEVEL: invalid level %q", values["LOG_LEVEL"]))
+	}
+
+	if len(errs) > 0 {
+		return Config{}, invalid(errs)
+	}
+	return cfg, nil
+}
+
+func invalid(errs []error) error {
+	return fmt.Errorf("config: invalid configuration:\n%w", errors.Join(errs...))
+}
+
+// Validate checks everything the API server needs to run.
+func (c Config) Validate() error {
+	var errs []error
+	if strings.TrimSpace(c.ListenAddr) == "" {
+		errs = append(errs, errors.New("LISTEN_ADDR: must not be empty"))
+	}
+	if c.DBURL == "" {
+		errs = append(errs, errors.New("DB_URL: is required"))
+	}
+	if c.JWTSecret == "" {
+		errs = append(errs, errors.New("JWT_SECRET: is required"))
+	}
+	if c.AccessTokenTTL > 0 && c.RefreshTokenTTL > 0 && c.AccessTokenTTL >= c.RefreshTokenTTL {
+		errs = append(errs, errors.New("ACCESS_TOKEN_TTL: must be shorter than REFRESH_TOKEN_TTL"))
+	}
+	if c.Platform != "dev" && c.Platform != "prod" {
+		errs = append(errs, fmt.Errorf("PLATFORM: must be dev or prod, got %q", c.Platform))
+	}
+	if c.ReadHeaderTimeout > 0 && c.ReadTimeout > 0 && c.ReadHeaderTimeout > c.ReadTimeout {
+		errs = append(errs, errors.New("READ_HEADER_TIMEOUT: must not exceed READ_TIMEOUT"))
+	}
+	if c.MaxChirpLength < 1 {
+		errs = append(errs, errors.New("MAX_CHIRP_LENGTH: must be at least 1"))
+	}
+	if c.ChirpURLLength < 1 || c.ChirpURLLength > c.MaxChirpLength {
+		errs = append(errs, errors.New("CHIRP_URL_LENGTH: must be between 1 and MAX_CHIRP_LENGTH"))
+	}
+	switch c.Mailer {
+	case "log":
+	case "smtp":
+		if c.SMTPAddr == "" {
+			errs = append(errs, errors.New("SMTP_ADDR: is required when MAILER is smtp"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("MAILER: must be log or smtp, got %q", c.Mailer))
+	}
+	if c.LoginLockoutThreshold < 1 {
+		errs = append(errs, errors.New("LOGIN_LOCKOUT_THRESHOLD: must be at least 1"))
+	}
+	if c.LoginIPLockoutThreshold < 1 {
+		errs = append(errs, errors.New("LOGIN_IP_LOCKOUT_THRESHOLD: must be at least 1"))
+	}
+	if len(errs) > 0 {
+		return invalid(errs)
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package config
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func envFrom(values map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		v, ok := values[key]
+		return v, ok
+	}
+}
+
+func writeEnvFile(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), ".env")
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatalf("Failed to write env file: %v", err)
+	}
+	return path
+}
+
+func TestLoadDefaults(t *testing.T) {
+	env := envFrom(map[string]string{
+		"DB_URL":     "postgres://localhost/chirpy",
+		"JWT_SECRET": "secret",
+	})
+
+	cfg, err := Load([]string{"-env-file", writeEnvFile(t, "")}, env)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Expected valid config, got %v", err)
+	}
+	if cfg.MigrateOnStart {
+		t.Fatal("Expected migrations not to run on start by default")
+	}
+	if cfg.ListenAddr != ":8080" {
+		t.Fatalf("Expected default listen address, got '%s'", cfg.ListenAddr)
+	}
+	if cfg.AccessTokenTTL != time.Hour {
+		t.Fatalf("Expected 1h access TTL, got %v", cfg.AccessTokenTTL)
+	}
+	if cfg.Platform != "prod" || cfg.IsDev() {
+		t.Fatalf("Expected prod platform, got '%s'", cfg.Platform)
+	}
+	if cfg.MaxChirpLength != 140 || cfg.ChirpURLLength != 23 {
+		t.Fatalf("Expected max chirp length 140 with links counting 23, got %d and %d", cfg.MaxChirpLength, cfg.ChirpURLLength)
+	}
+	if cfg.ChirpEditWindow != 15*time.Minute {
+		t.Fatalf("Expected a 15m chirp edit window, got %v", cfg.ChirpEditWindow)
+	}
+	if cfg.ReadHeaderTimeout != 5*time.Second || cfg.IdleTimeout != time.Minute {
+		t.Fatalf("Unexpected server timeouts: %+v", cfg)
+	}
+	if cfg.ShutdownDelay != 0 || cfg.ShutdownTimeout != 20*time.Second {
+		t.Fatalf("Unexpected shutdown settings: delay %v, timeout %v", cfg.ShutdownDelay, cfg.ShutdownTimeout)
+	}
+	if cfg.LogLevel != slog.LevelInfo {
+		t.Fatalf("Expected info log level, got %v", cfg.LogLevel)
+	}
+	if login := cfg.RateLimits["POST /api/login"]; login.Requests != 5 || login.Per != time.Minute {
+		t.Fatalf("Expected default login rate limit of 5/1m, got %v", login)
+	}
+	if cfg.Mailer != "log" || cfg.EmailVerificationTTL != 24*time.Hour || cfg.PasswordResetTTL != time.Hour {
+		t.Fatalf("Unexpected mail defaults: mailer %q, verification TTL %v, reset TTL %v", cfg.Mailer, cfg.EmailVerificationTTL, cfg.PasswordResetTTL)
+	}
+	if cfg.RateLimitStore != "memory" || cfg.TrustProxyHeaders {
+		t.Fatalf("Unexpected rate limit defaults: store %q, trust proxy %v", cfg.RateLimitStore, cfg.TrustProxyHeaders)
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	envFile := writeEnvFile(t, "DB_URL=postgres://file\nJWT_SECRET=file-secret\nPLATFORM=dev\nLISTEN_ADDR=:7000\n")
+	env := envFrom(map[string]string{
+		"JWT_SECRET":  "env-secret",
+		"LISTEN_ADDR": ":9000",
+	})
+
+	cfg, err := Load([]string{"-env-file", envFile, "-addr", ":9999", "-migrate-on-start"}, env)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if cfg.DBURL != "postgres://file" {
+		t.Fatalf("Expected DB URL from file, got '%s'", cfg.DBURL)
+	}
+	if cfg.JWTSecret != "env-secret" {
+		t.Fatalf("Expected environment to override file, got '%s'", cfg.JWTSecret)
+	}
+	if cfg.ListenAddr != ":9999" {
+		t.Fatalf("Expected flag to override environment, got '%s'", cfg.ListenAddr)
+	}
+	if !cfg.IsDev() {
+		t.Fatal("Expected dev platform from file")
+	}
+	if !cfg.MigrateOnStart {
+		t.Fatal("Expected bare -migrate-on-start flag to enable migrations")
+	}
+}
+
+func TestLoadMissingEnvFile(t *testing.T) {
+	env := envFrom(map[string]string{"DB_URL": "postgres://x", "JWT_SECRET": "s"})
+	missing := filepath.Join(t.TempDir(), "missing.env")
+
+	if _, err := Load([]string{"-env-file", missing}, env); err == nil {
+		t.Fatal("Expected error for explicitly named missing env file, got nil")
+	}
+}
+
+func TestLoadMalformedValues(t *testing.T) {
+	env := envFrom(map[string]string{
+		"ACCESS_TOKEN_TTL":        "forever",
+		"MAX_CHIRP_LENGTH":        "lots",
+		"MODERATION_POLICY":       "shout",
+		"SHUTDOWN_TIMEOUT":        "0s",
+		"MIGRATE_ON_START":        "maybe",
+		"LOG_LEVEL":               "loud",
+		"RATE_LIMITS":             "POST /api/login=fast",
+		"RATE_LIMIT_STORE":        "redis",
+		"LOGIN_LOCKOUT_THRESHOLD": "ten",
+	})
+
+	_, err := Load([]string{"-env-file", writeEnvFile(t, "")}, env)
+	if err == nil {
+		t.Fatal("Expected error, got nil")
+	}
+	for _, want := range []string{"ACCESS_TOKEN_TTL", "MAX_CHIRP_LENGTH", "MODERATION_POLICY", "SHUTDOWN_TIMEOUT", "MIGRATE_ON_START", "LOG_LEVEL", "RATE_LIMITS", "RATE_LIMIT_STORE", "LOGIN_LOCKOUT_THRESHOLD"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Fatalf("Expected error to mention %s, got: %v", want, err)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	env := envFrom(map[string]string{
+		"PLATFORM":                   "staging",
+		"MAX_CHIRP_LENGTH":           "0",
+		"ACCESS_TOKEN_TTL":           "2000h",
+		"LOGIN_IP_LOCKOUT_THRESHOLD": "0",
+		"MAILER":                     "smtp",
+	})
+
+	cfg, err := Load([]string{"-env-file", writeEnvFile(t, "")}, env)
+	if err != nil {
+		t.Fatalf("Expected no load error, got %v", err)
+	}
+	err = cfg.Validate()
+	if err == nil {
+		t.Fatal("Expected validation error, got nil")
+	}
+	for _, want := range []string{"DB_URL", "JWT_SECRET", "ACCESS_TOKEN_TTL", "PLATFORM", "MAX_CHIRP_LENGTH", "CHIRP_URL_LENGTH", "LOGIN_IP_LOCKOUT_THRESHOLD", "SMTP_ADDR"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Fatalf("Expected error to mention %s, got: %v", want, err)
+		}
+	}
+}
+
+func TestLoadUnknownFlag(t *testing.T) {
+	if _, err := Load([]string{"-no-such-flag"}, envFrom(nil)); err == nil {
+		t.Fatal("Expected error for unknown flag, got nil")
+	}
+}
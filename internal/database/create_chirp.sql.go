@@ -13,18 +13,19 @@ import (
 
 const createChirp = `-- name: CreateChirp :one
 INSERT INTO chirps (
-  id, body, user_id
-) VALUES (gen_random_uuid(), $1, $2)
-  RETURNING id, created_at, updated_at, body, user_id
+  id, body, user_id, in_reply_to
+) VALUES (gen_random_uuid(), $1, $2, $3)
+  RETURNING id, created_at, updated_at, body, user_id, revision_count, in_reply_to, reply_count, like_count
 `
 
 type CreateChirpParams struct {
-	Body   string
-	UserID uuid.UUID
+	Body      string
+	UserID    uuid.UUID
+	InReplyTo uuid.NullUUID
 }
 
 func (q *Queries) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp, error) {
-	row := q.db.QueryRowContext(ctx, createChirp, arg.Body, arg.UserID)
+	row := q.db.QueryRowContext(ctx, createChirp, arg.Body, arg.UserID, arg.InReplyTo)
 	var i Chirp
 	err := row.Scan(
 		&i.ID,
@@ -32,6 +33,10 @@ func (q *Queries) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp
 		&i.UpdatedAt,
 		&i.Body,
 		&i.UserID,
+		&i.RevisionCount,
+		&i.InReplyTo,
+		&i.ReplyCount,
+		&i.LikeCount,
 	)
 	return i, err
 }
@@ -12,7 +12,7 @@ import (
 )
 
 const getSingleChirp = `-- name: GetSingleChirp :one
-SELECT id, created_at, updated_at, body, user_id FROM chirps 
+SELECT id, created_at, updated_at, body, user_id, revision_count, in_reply_to, reply_count, like_count FROM chirps 
   WHERE id = ($1)
 `
 
@@ -25,6 +25,10 @@ func (q *Queries) GetSingleChirp(ctx context.Context, id uuid.UUID) (Chirp, erro
 		&i.UpdatedAt,
 		&i.Body,
 		&i.UserID,
+		&i.RevisionCount,
+		&i.InReplyTo,
+		&i.ReplyCount,
+		&i.LikeCount,
 	)
 	return i, err
 }
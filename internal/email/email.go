@@ -0,0 +1,157 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const maxAddressLength = 254
+
+// Normalize validates a bare RFC 5322 address such as "walt@example.com" and
+// returns it case-folded, so addresses differing only by case are the same
+// account. Display names, comments and group syntax are rejected.
+func Normalize(address string) (string, error) {
+	address = strings.TrimSpace(address)
+	if address == "" {
+		return "", errors.New("email address is empty")
+	}
+	if len(address) > maxAddressLength {
+		return "", errors.New("email address is too long")
+	}
+	parsed, err := mail.ParseAddress(address)
+	if err != nil {
+		return "", fmt.Errorf("invalid email address: %w", err)
+	}
+	if parsed.Name != "" {
+		return "", errors.New("invalid email address: expected a bare address")
+	}
+	// Build the result from what was parsed rather than from the input, which
+	// may carry comments such as "walt@example.com (work)". String quotes the
+	// local part again where needed; anything else in the input is rejected
+	canonical := strings.TrimSuffix(strings.TrimPrefix((&mail.Address{Address: parsed.Address}).String(), "<"), ">")
+	if !strings.EqualFold(canonical, address) {
+		return "", errors.New("invalid email address: expected a bare address")
+	}
+	at := strings.LastIndex(parsed.Address, "@")
+	domain := parsed.Address[at+1:]
+	if !strings.Contains(domain, ".") || strings.HasSuffix(domain, ".") {
+		return "", errors.New("invalid email address: domain must be fully qualified")
+	}
+	return strings.ToLower(canonical), nil
+}
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// format renders msg as a plain-text RFC 5322 message.
+func format(from string, msg Message, date time.Time) ([]byte, error) {
+	for _, header := range []string{from, msg.To, msg.Subject} {
+		if strings.ContainsAny(header, "\r\n") {
+			return nil, errors.New("email header contains a line break")
+		}
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("\r\n")
+	buf.WriteString(strings.ReplaceAll(strings.ReplaceAll(msg.Body, "\r\n", "\n"), "\n", "\r\n"))
+	return buf.Bytes(), nil
+}
+
+// WriterMailer writes every message to w instead of delivering it. It is
+// meant for local development and tests.
+type WriterMailer struct {
+	from string
+
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewWriterMailer(from string, w io.Writer) *WriterMailer {
+	return &WriterMailer{from: from, w: w}
+}
+
+func (m *WriterMailer) Send(_ context.Context, msg Message) error {
+	data, err := format(m.from, msg, time.Now())
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, err = fmt.Fprintf(m.w, "%s\r\n\r\n----\r\n", data)
+	return err
+}
+
+// SMTPMailer delivers messages through an SMTP relay. Credentials are
+// optional; net/smtp only sends them over TLS or to localhost.
+type SMTPMailer struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+func NewSMTPMailer(addr, username, password, from string) (*SMTPMailer, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SMTP address %q: %w", addr, err)
+	}
+	m := &SMTPMailer{addr: addr, from: from}
+	if username != "" {
+		m.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return m, nil
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	data, err := format(m.from, msg, time.Now())
+	if err != nil {
+		return err
+	}
+
+	// net/smtp has no context support, so run it aside and stop waiting
+	// once the request is gone
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(m.addr, m.auth, envelopeAddress(m.from), []string{msg.To}, data)
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("error sending email: %w", err)
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// envelopeAddress strips a display name from a From header value.
+func envelopeAddress(from string) string {
+	parsed, err := mail.ParseAddress(from)
+	if err != nil {
+		return from
+	}
+	return parsed.Address
+}
@@ -0,0 +1,83 @@
+package email
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{in: "walt@breakingbad.com", want: "walt@breakingbad.com"},
+		{in: "  Walt.White@BreakingBad.COM ", want: "walt.white@breakingbad.com"},
+		{in: "jesse+cooks@example.co.uk", want: "jesse+cooks@example.co.uk"},
+		{in: `"saul goodman"@example.com`, want: `"saul goodman"@example.com`},
+		{in: "", wantErr: true},
+		{in: "walt", wantErr: true},
+		{in: "walt@", wantErr: true},
+		{in: "@example.com", wantErr: true},
+		{in: "walt@localhost", wantErr: true},
+		{in: "walt@example.com.", wantErr: true},
+		{in: "Walt <walt@example.com>", wantErr: true},
+		{in: "<walt@example.com>", wantErr: true},
+		{in: "walt@example.com, jesse@example.com", wantErr: true},
+		{in: "walt@exa mple.com", wantErr: true},
+		{in: "walt@example.com ()", wantErr: true},
+		{in: "walt@example.com (work)", wantErr: true},
+		{in: "(work) walt@example.com", wantErr: true},
+		{in: "walt(work)@example.com", wantErr: true},
+		{in: `"walt"@example.com`, wantErr: true},
+		{in: strings.Repeat("a", 250) + "@example.com", wantErr: true},
+	}
+
+	for _, tc := range tests {
+		got, err := Normalize(tc.in)
+		if (err != nil) != tc.wantErr {
+			t.Fatalf("Normalize(%q) error = %v, wantErr %v", tc.in, err, tc.wantErr)
+		}
+		if got != tc.want {
+			t.Fatalf("Normalize(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestWriterMailer(t *testing.T) {
+	var out strings.Builder
+	mailer := NewWriterMailer("Chirpy <no-reply@chirpy.test>", &out)
+
+	err := mailer.Send(context.Background(), Message{To: "walt@example.com", Subject: "Verify your email", Body: "line one\nline two"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	for _, want := range []string{
+		"From: Chirpy <no-reply@chirpy.test>\r\n",
+		"To: walt@example.com\r\n",
+		"Subject: Verify your email\r\n",
+		"Content-Type: text/plain; charset=utf-8\r\n\r\nline one\r\nline two",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("Expected message to contain %q, got:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestFormatRejectsHeaderInjection(t *testing.T) {
+	_, err := format("no-reply@chirpy.test", Message{To: "walt@example.com\r\nBcc: everyone@example.com", Subject: "hi"}, time.Now())
+	if err == nil {
+		t.Fatal("Expected error for a recipient with a line break, got nil")
+	}
+}
+
+func TestNewSMTPMailer(t *testing.T) {
+	if _, err := NewSMTPMailer("smtp.example.com", "", "", "no-reply@chirpy.test"); err == nil {
+		t.Fatal("Expected error for an address without a port, got nil")
+	}
+	if got := envelopeAddress("Chirpy <no-reply@chirpy.test>"); got != "no-reply@chirpy.test" {
+		t.Fatalf("Expected bare envelope address, got '%s'", got)
+	}
+}
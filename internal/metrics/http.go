@@ -0,0 +1,119 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// unmatchedRoute labels requests no route pattern matched, so arbitrary
+// paths cannot blow up label cardinality.
+const unmatchedRoute = "unmatched"
+
+// otherMethod labels requests with a non-standard method. net/http accepts
+// any token as a method, so labelling them verbatim would let clients create
+// unbounded series.
+const otherMethod = "other"
+
+// MethodLabel returns method if it is one of the standard HTTP methods and
+// otherMethod otherwise.
+func MethodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	}
+	return otherMethod
+}
+
+// HTTPMetrics records per-route request counts, latency, response sizes and
+// in-flight requests.
+type HTTPMetrics struct {
+	requests *CounterVec
+	duration *HistogramVec
+	size     *HistogramVec
+	inFlight *Gauge
+}
+
+func NewHTTPMetrics(r *Registry) *HTTPMetrics {
+	return &HTTPMetrics{
+		requests: r.NewCounterVec("chirpy_http_requests_total", "HTTP requests by route, method and status class.", "route", "method", "code"),
+		duration: r.NewHistogramVec("chirpy_http_request_duration_seconds", "HTTP request latency by route and method.", DefBuckets, "route", "method"),
+		size:     r.NewHistogramVec("chirpy_http_response_size_bytes", "HTTP response body size by route and method.", SizeBuckets, "route", "method"),
+		inFlight: r.NewGauge("chirpy_http_requests_in_flight", "HTTP requests currently being served."),
+	}
+}
+
+// Middleware instruments next, which should be the ServeMux itself: the route
+// label is the pattern the mux matched, read back once it has served r.
+func (m *HTTPMetrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.inFlight.Inc()
+		defer m.inFlight.Dec()
+
+		start := time.Now()
+		rec := NewResponseRecorder(w)
+		next.ServeHTTP(rec, r)
+
+		route := r.Pattern
+		if route == "" {
+			route = unmatchedRoute
+		}
+		method := MethodLabel(r.Method)
+		m.requests.Inc(route, method, StatusClass(rec.Status()))
+		m.duration.Observe(time.Since(start).Seconds(), route, method)
+		m.size.Observe(float64(rec.Bytes()), route, method)
+	})
+}
+
+// StatusClass buckets a status code as 1xx through 5xx.
+func StatusClass(status int) string {
+	if status < 100 || status > 599 {
+		return "unknown"
+	}
+	return strconv.Itoa(status/100) + "xx"
+}
+
+// ResponseRecorder captures the status code and body size written through it.
+type ResponseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func NewResponseRecorder(w http.ResponseWriter) *ResponseRecorder {
+	return &ResponseRecorder{ResponseWriter: w}
+}
+
+func (rec *ResponseRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *ResponseRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (rec *ResponseRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// Status reports the status sent, or 200 if the handler wrote nothing.
+func (rec *ResponseRecorder) Status() int {
+	if rec.status == 0 {
+		return http.StatusOK
+	}
+	return rec.status
+}
+
+func (rec *ResponseRecorder) Bytes() int64 {
+	return rec.bytes
+}
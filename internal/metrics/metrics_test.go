@@ -0,0 +1,137 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWriteText(t *testing.T) {
+	r := NewRegistry()
+	requests := r.NewCounterVec("requests_total", "Requests.", "route")
+	r.NewCounter("plain_total", "No labels.")
+	gauge := r.NewGauge("in_flight", "In flight.")
+	r.NewGaugeFunc("pool_open", "Open connections.", func() float64 { return 3 })
+	hist := r.NewHistogramVec("latency_seconds", "Latency.", []float64{0.1, 1}, "route")
+
+	requests.Inc("/a")
+	requests.Add(2, `/b"quoted"`)
+	gauge.Inc()
+	gauge.Inc()
+	gauge.Dec()
+	hist.Observe(0.05, "/a")
+	hist.Observe(0.5, "/a")
+	hist.Observe(5, "/a")
+
+	var out strings.Builder
+	if err := r.WriteText(&out); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	for _, want := range []string{
+		"# HELP requests_total Requests.\n# TYPE requests_total counter\n",
+		`requests_total{route="/a"} 1` + "\n",
+		`requests_total{route="/b\"quoted\""} 2` + "\n",
+		"plain_total 0\n",
+		"# TYPE in_flight gauge\nin_flight 1\n",
+		"pool_open 3\n",
+		"# TYPE latency_seconds histogram\n",
+		`latency_seconds_bucket{route="/a",le="0.1"} 1` + "\n",
+		`latency_seconds_bucket{route="/a",le="1"} 2` + "\n",
+		`latency_seconds_bucket{route="/a",le="+Inf"} 3` + "\n",
+		`latency_seconds_sum{route="/a"} 5.55` + "\n",
+		`latency_seconds_count{route="/a"} 3` + "\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("Expected output to contain %q, got:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestCounterRejectsWrongLabelCount(t *testing.T) {
+	c := NewRegistry().NewCounterVec("c_total", "C.", "a", "b")
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Expected panic for wrong number of label values")
+		}
+	}()
+	c.Inc("only-one")
+}
+
+func TestDuplicateRegistrationPanics(t *testing.T) {
+	r := NewRegistry()
+	r.NewGauge("g", "G.")
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Expected panic for duplicate metric name")
+		}
+	}()
+	r.NewGauge("g", "G.")
+}
+
+func TestHTTPMiddleware(t *testing.T) {
+	r := NewRegistry()
+	m := NewHTTPMetrics(r)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /items/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	})
+	mux.HandleFunc("POST /items", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	})
+	handler := m.Middleware(mux)
+
+	for _, req := range []*http.Request{
+		httptest.NewRequest(http.MethodGet, "/items/1", nil),
+		httptest.NewRequest(http.MethodGet, "/items/2", nil),
+		httptest.NewRequest(http.MethodPost, "/items", nil),
+		httptest.NewRequest(http.MethodGet, "/nowhere/123", nil),
+	} {
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	if got := m.requests.Value("GET /items/{id}", "GET", "2xx"); got != 2 {
+		t.Fatalf("Expected 2 requests for the pattern, got %v", got)
+	}
+	if got := m.requests.Value("POST /items", "POST", "4xx"); got != 1 {
+		t.Fatalf("Expected 1 client error, got %v", got)
+	}
+	if got := m.requests.Value(unmatchedRoute, "GET", "4xx"); got != 1 {
+		t.Fatalf("Expected unmatched path under %q, got %v", unmatchedRoute, got)
+	}
+	for _, method := range []string{"FOO1", "FOO2", "get"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, "/items/1", nil))
+	}
+	if got := m.requests.Value(unmatchedRoute, otherMethod, "4xx"); got != 3 {
+		t.Fatalf("Expected non-standard methods under %q, got %v", otherMethod, got)
+	}
+	if got := m.inFlight.Value(); got != 0 {
+		t.Fatalf("Expected no requests in flight, got %v", got)
+	}
+
+	var out strings.Builder
+	r.WriteText(&out)
+	if !strings.Contains(out.String(), `chirpy_http_response_size_bytes_sum{route="GET /items/{id}",method="GET"} 10`) {
+		t.Fatalf("Expected response sizes to be recorded, got:\n%s", out.String())
+	}
+}
+
+func TestStatusClass(t *testing.T) {
+	tests := map[int]string{
+		101: "1xx",
+		200: "2xx",
+		204: "2xx",
+		302: "3xx",
+		429: "4xx",
+		503: "5xx",
+		0:   "unknown",
+		999: "unknown",
+	}
+	for status, want := range tests {
+		if got := StatusClass(status); got != want {
+			t.Errorf("StatusClass(%d) = %q, want %q", status, got, want)
+		}
+	}
+}
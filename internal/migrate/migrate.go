@@ -0,0 +1,83 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"io/fs"
+
+	"github.com/pressly/goose/v3"
+	"github.com/pressly/goose/v3/lock"
+)
+
+var Commands = []string{"up", "down", "status", "redo"}
+
+// NewProvider returns a goose provider for the migrations at the root of
+// fsys. Every run holds a Postgres advisory lock so replicas starting at the
+// same time apply migrations one at a time.
+func NewProvider(db *sql.DB, fsys fs.FS) (*goose.Provider, error) {
+	locker, err := lock.NewPostgresSessionLocker()
+	if err != nil {
+		return nil, fmt.Errorf("error creating migration lock: %w", err)
+	}
+	provider, err := goose.NewProvider(goose.DialectPostgres, db, fsys, goose.WithSessionLocker(locker))
+	if err != nil {
+		return nil, fmt.Errorf("error loading migrations: %w", err)
+	}
+	return provider, nil
+}
+
+func Run(ctx context.Context, provider *goose.Provider, command string, out io.Writer) error {
+	switch command {
+	case "up":
+		results, err := provider.Up(ctx)
+		for _, result := range results {
+			fmt.Fprintln(out, result)
+		}
+		if err != nil {
+			return fmt.Errorf("migrate up: %w", err)
+		}
+		if len(results) == 0 {
+			fmt.Fprintln(out, "no pending migrations")
+		}
+	case "down":
+		result, err := provider.Down(ctx)
+		if result != nil {
+			fmt.Fprintln(out, result)
+		}
+		if err != nil {
+			return fmt.Errorf("migrate down: %w", err)
+		}
+	case "redo":
+		result, err := provider.Down(ctx)
+		if result != nil {
+			fmt.Fprintln(out, result)
+		}
+		if err != nil {
+			return fmt.Errorf("migrate redo: %w", err)
+		}
+		result, err = provider.UpByOne(ctx)
+		if result != nil {
+			fmt.Fprintln(out, result)
+		}
+		if err != nil {
+			return fmt.Errorf("migrate redo: %w", err)
+		}
+	case "status":
+		statuses, err := provider.Status(ctx)
+		if err != nil {
+			return fmt.Errorf("migrate status: %w", err)
+		}
+		for _, status := range statuses {
+			appliedAt := "Pending"
+			if status.State == goose.StateApplied {
+				appliedAt = status.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(out, "%-19s  %s\n", appliedAt, status.Source.Path)
+		}
+	default:
+		return fmt.Errorf("unknown migrate command %q (expected one of %v)", command, Commands)
+	}
+	return nil
+}
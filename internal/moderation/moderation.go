@@ -0,0 +1,150 @@
+package moderation
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+)
+
+const mask = "****"
+
+// Filter inspects a chirp body and either returns a (possibly rewritten) body
+// or an error explaining why the chirp must be rejected.
+type Filter interface {
+	Apply(body string) (string, error)
+}
+
+// Chain runs filters in order, feeding each one the output of the last.
+type Chain []Filter
+
+func (c Chain) Apply(body string) (string, error) {
+	var err error
+	for _, f := range c {
+		body, err = f.Apply(body)
+		if err != nil {
+			return "", err
+		}
+	}
+	return body, nil
+}
+
+type Policy int
+
+const (
+	// PolicyRewrite masks offending words and lets the chirp through.
+	PolicyRewrite Policy = iota
+	// PolicyReject refuses the chirp outright.
+	PolicyReject
+)
+
+func ParsePolicy(s string) (Policy, error) {
+	switch strings.ToLower(s) {
+	case "", "rewrite":
+		return PolicyRewrite, nil
+	case "reject":
+		return PolicyReject, nil
+	default:
+		return PolicyRewrite, fmt.Errorf("unknown moderation policy %q", s)
+	}
+}
+
+type RejectedError struct {
+	Word string
+}
+
+func (e *RejectedError) Error() string {
+	return fmt.Sprintf("chirp contains banned word %q", e.Word)
+}
+
+var DefaultBannedWords = []string{"kerfuffle", "sharbert", "fornax"}
+
+type BannedWords struct {
+	words  map[string]struct{}
+	policy Policy
+}
+
+func NewBannedWords(words []string, policy Policy) *BannedWords {
+	set := make(map[string]struct{}, len(words))
+	for _, word := range words {
+		word = strings.ToLower(strings.TrimSpace(word))
+		if word != "" {
+			set[word] = struct{}{}
+		}
+	}
+	return &BannedWords{words: set, policy: policy}
+}
+
+func (b *BannedWords) Apply(body string) (string, error) {
+	var out strings.Builder
+	out.Grow(len(body))
+
+	// Walk whitespace-separated words, keeping the original separators intact
+	start := -1
+	for i, r := range body {
+		if unicode.IsSpace(r) {
+			if start >= 0 {
+				masked, err := b.maskWord(body[start:i])
+				if err != nil {
+					return "", err
+				}
+				out.WriteString(masked)
+				start = -1
+			}
+			out.WriteRune(r)
+			continue
+		}
+		if start < 0 {
+			start = i
+		}
+	}
+	if start >= 0 {
+		masked, err := b.maskWord(body[start:])
+		if err != nil {
+			return "", err
+		}
+		out.WriteString(masked)
+	}
+
+	return out.String(), nil
+}
+
+func (b *BannedWords) maskWord(word string) (string, error) {
+	// Punctuation attached to a word ("kerfuffle!") should not hide it
+	core := strings.TrimFunc(word, unicode.IsPunct)
+	if core == "" {
+		return word, nil
+	}
+	if _, banned := b.words[strings.ToLower(core)]; !banned {
+		return word, nil
+	}
+	if b.policy == PolicyReject {
+		return "", &RejectedError{Word: core}
+	}
+	prefixLen := strings.Index(word, core)
+	return word[:prefixLen] + mask + word[prefixLen+len(core):], nil
+}
+
+// LoadWordList reads one word per line, skipping blank lines and # comments.
+func LoadWordList(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error: error opening word list: %s", err)
+	}
+	defer file.Close()
+
+	words := []string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		words = append(words, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error: error reading word list: %s", err)
+	}
+	return words, nil
+}
@@ -0,0 +1,97 @@
+package moderation
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBannedWordsRewrite(t *testing.T) {
+	filter := NewBannedWords([]string{"kerfuffle", "Sharbert"}, PolicyRewrite)
+
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{name: "Clean body", body: "I had something interesting for breakfast", want: "I had something interesting for breakfast"},
+		{name: "Single word", body: "This is a kerfuffle opinion", want: "This is a **** opinion"},
+		{name: "Case insensitive", body: "KERFUFFLE and sharbert", want: "**** and ****"},
+		{name: "Attached punctuation", body: "What a kerfuffle! (Sharbert.)", want: "What a ****! (****.)"},
+		{name: "Substring untouched", body: "kerfuffles are fine", want: "kerfuffles are fine"},
+		{name: "Whitespace preserved", body: "  kerfuffle\tok\n", want: "  ****\tok\n"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := filter.Apply(tc.body)
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if got != tc.want {
+				t.Fatalf("Expected '%s', got '%s'", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestBannedWordsReject(t *testing.T) {
+	filter := NewBannedWords([]string{"fornax"}, PolicyReject)
+
+	_, err := filter.Apply("Look, a Fornax!")
+	var rejected *RejectedError
+	if !errors.As(err, &rejected) {
+		t.Fatalf("Expected RejectedError, got %v", err)
+	}
+	if rejected.Word != "Fornax" {
+		t.Fatalf("Expected rejected word 'Fornax', got '%s'", rejected.Word)
+	}
+
+	got, err := filter.Apply("nothing to see")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if got != "nothing to see" {
+		t.Fatalf("Expected body unchanged, got '%s'", got)
+	}
+}
+
+func TestChain(t *testing.T) {
+	chain := Chain{
+		NewBannedWords([]string{"kerfuffle"}, PolicyRewrite),
+		NewBannedWords([]string{"fornax"}, PolicyReject),
+	}
+
+	got, err := chain.Apply("kerfuffle here")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if got != "**** here" {
+		t.Fatalf("Expected '**** here', got '%s'", got)
+	}
+
+	if _, err := chain.Apply("kerfuffle fornax"); err == nil {
+		t.Fatal("Expected rejection from second filter, got nil")
+	}
+}
+
+func TestLoadWordList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "words.txt")
+	err := os.WriteFile(path, []byte("# banned\nkerfuffle\n\n  sharbert  \n"), 0o600)
+	if err != nil {
+		t.Fatalf("Failed to write word list: %v", err)
+	}
+
+	words, err := LoadWordList(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(words) != 2 || words[0] != "kerfuffle" || words[1] != "sharbert" {
+		t.Fatalf("Unexpected words: %v", words)
+	}
+
+	if _, err := LoadWordList(filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+		t.Fatal("Expected error for missing file, got nil")
+	}
+}
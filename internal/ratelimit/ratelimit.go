@@ -0,0 +1,212 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/YoavIsaacs/chirpy/internal/database"
+)
+
+// Limit is a token bucket holding Requests tokens that refills completely
+// over Per.
+type Limit struct {
+	Requests int
+	Per      time.Duration
+}
+
+// ParseLimit parses limits written as "<requests>/<duration>", e.g. "5/1m".
+func ParseLimit(s string) (Limit, error) {
+	countStr, perStr, found := strings.Cut(strings.TrimSpace(s), "/")
+	if !found {
+		return Limit{}, fmt.Errorf("invalid limit %q, expected <requests>/<duration>", s)
+	}
+	count, err := strconv.Atoi(countStr)
+	if err != nil || count < 1 {
+		return Limit{}, fmt.Errorf("invalid request count in limit %q", s)
+	}
+	per, err := time.ParseDuration(perStr)
+	if err != nil || per <= 0 {
+		return Limit{}, fmt.Errorf("invalid duration in limit %q", s)
+	}
+	return Limit{Requests: count, Per: per}, nil
+}
+
+// ParseRouteLimits parses a comma-separated list of "<route pattern>=<limit>"
+// entries, e.g. "POST /api/login=5/1m, POST /api/chirps=30/1m".
+func ParseRouteLimits(s string) (map[string]Limit, error) {
+	limits := map[string]Limit{}
+	for _, entry := range strings.Split(s, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		route, limitStr, found := strings.Cut(entry, "=")
+		route = strings.TrimSpace(route)
+		if !found || route == "" {
+			return nil, fmt.Errorf("invalid entry %q, expected <route>=<limit>", entry)
+		}
+		limit, err := ParseLimit(limitStr)
+		if err != nil {
+			return nil, err
+		}
+		limits[route] = limit
+	}
+	return limits, nil
+}
+
+func (l Limit) String() string {
+	return fmt.Sprintf("%d/%s", l.Requests, l.Per)
+}
+
+// The buckets use GCRA: instead of a token count each key stores the
+// theoretical arrival time (TAT) of its next request.
+func (l Limit) emissionInterval() time.Duration {
+	return l.Per / time.Duration(l.Requests)
+}
+
+func (l Limit) tolerance() time.Duration {
+	return l.emissionInterval() * time.Duration(l.Requests-1)
+}
+
+// Result describes the state of a bucket after a request took from it.
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	RetryAfter time.Duration
+	// ResetAfter is how long until the bucket is full again.
+	ResetAfter time.Duration
+}
+
+func (l Limit) result(now, tat time.Time, allowed bool) Result {
+	res := Result{
+		Allowed:    allowed,
+		Limit:      l.Requests,
+		ResetAfter: max(tat.Sub(now), 0),
+	}
+	interval := l.emissionInterval()
+	if allowed {
+		res.Remaining = int((l.tolerance() + interval - tat.Sub(now)) / interval)
+	} else {
+		res.RetryAfter = max(tat.Sub(now)-l.tolerance(), 0)
+	}
+	return res
+}
+
+// Store keeps the buckets. Take must be atomic per key.
+type Store interface {
+	Take(ctx context.Context, key string, limit Limit, now time.Time) (Result, error)
+}
+
+// sweepInterval is how often stores drop buckets that have refilled.
+const sweepInterval = time.Minute
+
+type MemoryStore struct {
+	mu        sync.Mutex
+	tats      map[string]time.Time
+	lastSweep time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{tats: map[string]time.Time{}}
+}
+
+func (s *MemoryStore) Take(_ context.Context, key string, limit Limit, now time.Time) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.lastSweep) >= sweepInterval {
+		for k, tat := range s.tats {
+			if !tat.After(now) {
+				delete(s.tats, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	tat := now
+	if stored, ok := s.tats[key]; ok && stored.After(now) {
+		tat = stored
+	}
+	if tat.Sub(now) > limit.tolerance() {
+		return limit.result(now, tat, false), nil
+	}
+	tat = tat.Add(limit.emissionInterval())
+	s.tats[key] = tat
+	return limit.result(now, tat, true), nil
+}
+
+// PostgresStore shares buckets between every instance using the database.
+type PostgresStore struct {
+	queries *database.Queries
+
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+func NewPostgresStore(queries *database.Queries) *PostgresStore {
+	return &PostgresStore{queries: queries}
+}
+
+func (s *PostgresStore) Take(ctx context.Context, key string, limit Limit, now time.Time) (Result, error) {
+	s.sweep(ctx, now)
+
+	tat, err := s.queries.TakeRateLimitToken(ctx, database.TakeRateLimitTokenParams{
+		Key:              key,
+		Now:              now.UnixNano(),
+		EmissionInterval: int64(limit.emissionInterval()),
+		Tolerance:        int64(limit.tolerance()),
+	})
+	if err == nil {
+		return limit.result(now, time.Unix(0, tat), true), nil
+	}
+	if err != sql.ErrNoRows {
+		return Result{}, fmt.Errorf("error taking rate limit token: %w", err)
+	}
+
+	// The conditional upsert left the row alone, so the request is denied
+	tat, err = s.queries.GetRateLimitTAT(ctx, key)
+	if err != nil {
+		return Result{}, fmt.Errorf("error reading rate limit: %w", err)
+	}
+	return limit.result(now, time.Unix(0, tat), false), nil
+}
+
+func (s *PostgresStore) sweep(ctx context.Context, now time.Time) {
+	s.mu.Lock()
+	due := now.Sub(s.lastSweep) >= sweepInterval
+	if due {
+		s.lastSweep = now
+	}
+	s.mu.Unlock()
+	if due {
+		// Best effort: stale rows only cost space
+		s.queries.DeleteExpiredRateLimits(ctx, now.UnixNano())
+	}
+}
+
+// Limiter applies per-route limits to caller keys.
+type Limiter struct {
+	store  Store
+	limits map[string]Limit
+	now    func() time.Time
+}
+
+func NewLimiter(store Store, limits map[string]Limit) *Limiter {
+	return &Limiter{store: store, limits: limits, now: time.Now}
+}
+
+// Allow takes a token for key from the bucket of route. ok is false when the
+// route has no limit configured.
+func (l *Limiter) Allow(ctx context.Context, route, key string) (res Result, ok bool, err error) {
+	limit, ok := l.limits[route]
+	if !ok {
+		return Result{}, false, nil
+	}
+	res, err = l.store.Take(ctx, route+"|"+key, limit, l.now())
+	return res, true, err
+}
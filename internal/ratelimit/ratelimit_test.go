@@ -0,0 +1,110 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestParseLimit(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Limit
+		wantErr bool
+	}{
+		{in: "5/1m", want: Limit{Requests: 5, Per: time.Minute}},
+		{in: " 100/1h ", want: Limit{Requests: 100, Per: time.Hour}},
+		{in: "5", wantErr: true},
+		{in: "0/1m", wantErr: true},
+		{in: "x/1m", wantErr: true},
+		{in: "5/soon", wantErr: true},
+		{in: "5/-1s", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseLimit(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("ParseLimit(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+		}
+		if got != tt.want {
+			t.Fatalf("ParseLimit(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseRouteLimits(t *testing.T) {
+	limits, err := ParseRouteLimits("POST /api/login=5/1m, GET /api/chirps/{chirpID}=100/1m,")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(limits) != 2 || limits["POST /api/login"].Requests != 5 || limits["GET /api/chirps/{chirpID}"].Requests != 100 {
+		t.Fatalf("Unexpected limits: %v", limits)
+	}
+
+	limits, err = ParseRouteLimits("")
+	if err != nil || len(limits) != 0 {
+		t.Fatalf("Expected empty string to disable limits, got %v, %v", limits, err)
+	}
+
+	for _, bad := range []string{"POST /api/login", "=5/1m", "POST /api/login=5"} {
+		if _, err := ParseRouteLimits(bad); err == nil {
+			t.Fatalf("Expected error for %q, got nil", bad)
+		}
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	store := NewMemoryStore()
+	limit := Limit{Requests: 3, Per: 3 * time.Second}
+	now := time.Unix(1700000000, 0)
+	ctx := context.Background()
+
+	for i, wantRemaining := range []int{2, 1, 0} {
+		res, err := store.Take(ctx, "k", limit, now)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if !res.Allowed || res.Remaining != wantRemaining || res.Limit != 3 {
+			t.Fatalf("Request %d: unexpected result %+v", i, res)
+		}
+	}
+
+	res, _ := store.Take(ctx, "k", limit, now)
+	if res.Allowed {
+		t.Fatal("Expected fourth request in the burst to be denied")
+	}
+	if res.RetryAfter != time.Second || res.ResetAfter != 3*time.Second {
+		t.Fatalf("Expected retry after 1s and reset after 3s, got %+v", res)
+	}
+
+	if res, _ := store.Take(ctx, "other", limit, now); !res.Allowed {
+		t.Fatal("Expected buckets to be independent per key")
+	}
+
+	res, _ = store.Take(ctx, "k", limit, now.Add(time.Second))
+	if !res.Allowed || res.Remaining != 0 {
+		t.Fatalf("Expected one token to refill after a second, got %+v", res)
+	}
+
+	res, _ = store.Take(ctx, "k", limit, now.Add(time.Hour))
+	if !res.Allowed || res.Remaining != 2 {
+		t.Fatalf("Expected a full bucket after an hour, got %+v", res)
+	}
+	if len(store.tats) != 1 {
+		t.Fatalf("Expected refilled buckets to be swept, have %d", len(store.tats))
+	}
+}
+
+func TestLimiterSkipsUnlimitedRoutes(t *testing.T) {
+	limiter := NewLimiter(NewMemoryStore(), map[string]Limit{"POST /api/login": {Requests: 1, Per: time.Minute}})
+	ctx := context.Background()
+
+	if _, limited, _ := limiter.Allow(ctx, "GET /api/chirps", "ip:1.2.3.4"); limited {
+		t.Fatal("Expected route without a limit to be unlimited")
+	}
+	if res, limited, _ := limiter.Allow(ctx, "POST /api/login", "ip:1.2.3.4"); !limited || !res.Allowed {
+		t.Fatalf("Expected first login to be allowed, got %+v", res)
+	}
+	if res, _, _ := limiter.Allow(ctx, "POST /api/login", "ip:1.2.3.4"); res.Allowed {
+		t.Fatal("Expected second login to be denied")
+	}
+}
@@ -0,0 +1,83 @@
+package static
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func newTestHandler() *Handler {
+	fsys := fstest.MapFS{
+		"index.html":       {Data: []byte("<h1>Welcome to Chirpy</h1>")},
+		"assets/logo.png":  {Data: []byte("png")},
+		".env":             {Data: []byte("DB_URL=postgres://secret")},
+		".git/config":      {Data: []byte("[core]")},
+		"assets/.DS_Store": {Data: []byte("junk")},
+		"empty/readme.txt": {Data: []byte("no index here")},
+	}
+	return NewHandler(fsys, time.Hour)
+}
+
+func TestHandlerServesFiles(t *testing.T) {
+	h := newTestHandler()
+
+	tests := []struct {
+		name         string
+		path         string
+		wantStatus   int
+		wantCacheCtl string
+	}{
+		{name: "Root index", path: "/", wantStatus: http.StatusOK, wantCacheCtl: "no-cache"},
+		{name: "Explicit index", path: "/index.html", wantStatus: http.StatusOK, wantCacheCtl: "no-cache"},
+		{name: "Asset", path: "/assets/logo.png", wantStatus: http.StatusOK, wantCacheCtl: "public, max-age=3600"},
+		{name: "Dotfile", path: "/.env", wantStatus: http.StatusNotFound},
+		{name: "Dot directory", path: "/.git/config", wantStatus: http.StatusNotFound},
+		{name: "Nested dotfile", path: "/assets/.DS_Store", wantStatus: http.StatusNotFound},
+		{name: "Traversal", path: "/assets/../.env", wantStatus: http.StatusNotFound},
+		{name: "Directory without index", path: "/empty/", wantStatus: http.StatusNotFound},
+		{name: "Missing file", path: "/nope.txt", wantStatus: http.StatusNotFound},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tc.path, nil))
+
+			if rec.Code != tc.wantStatus {
+				t.Fatalf("Expected status %d, got %d", tc.wantStatus, rec.Code)
+			}
+			if tc.wantCacheCtl != "" && rec.Header().Get("Cache-Control") != tc.wantCacheCtl {
+				t.Fatalf("Expected Cache-Control '%s', got '%s'", tc.wantCacheCtl, rec.Header().Get("Cache-Control"))
+			}
+		})
+	}
+}
+
+func TestHandlerETag(t *testing.T) {
+	h := newTestHandler()
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/assets/logo.png", nil))
+	etag := rec.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("Expected an ETag header")
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/assets/logo.png", nil)
+	req.Header.Set("If-None-Match", etag)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotModified {
+		t.Fatalf("Expected status %d, got %d", http.StatusNotModified, rec.Code)
+	}
+}
+
+func TestHandlerRejectsWrites(t *testing.T) {
+	rec := httptest.NewRecorder()
+	newTestHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("Expected status %d, got %d", http.StatusMethodNotAllowed, rec.Code)
+	}
+}
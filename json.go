@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+)
+
+// Machine-readable error codes returned in the "code" field of error bodies.
+const (
+	errCodeBadRequest       = "bad_request"
+	errCodeInvalidJSON      = "invalid_json"
+	errCodeInvalidID        = "invalid_id"
+	errCodeUnauthorized     = "unauthorized"
+	errCodeForbidden        = "forbidden"
+	errCodeNotFound         = "not_found"
+	errCodeMethodNotAllowed = "method_not_allowed"
+	errCodeConflict         = "conflict"
+	errCodeUnprocessable    = "unprocessable"
+	errCodeRateLimited      = "rate_limited"
+	errCodeInternal         = "internal_error"
+)
+
+type errorResponse struct {
+	Error string `json:"error"`
+	Code  string `json:"code"`
+}
+
+func respondWithError(w http.ResponseWriter, r *http.Request, status int, code, msg string, err error) {
+	if err != nil {
+		level := slog.LevelInfo
+		if status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		requestLogger(r.Context()).Log(r.Context(), level, msg, "status", status, "code", code, "error", err)
+	}
+	respondWithJSON(w, status, errorResponse{
+		Error: msg,
+		Code:  code,
+	})
+}
+
+func respondWithJSON(w http.ResponseWriter, status int, payload interface{}) {
+	responseData, err := json.Marshal(payload)
+	if err != nil {
+		slog.Error("error marshalling response", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(responseData)
+}
+
+func decodeJSON(r *http.Request, dest interface{}) error {
+	decoder := json.NewDecoder(r.Body)
+	return decoder.Decode(dest)
+}
+
+// middlewareJSONErrors replaces the plain text 404 and 405 responses that mux
+// writes when no route matches with the JSON error body every handler uses.
+// Handlers on matched routes keep their own responses.
+func middlewareJSONErrors(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.ServeHTTP(&unmatchedRouteWriter{ResponseWriter: w, r: r}, r)
+	})
+}
+
+// unmatchedRouteWriter relies on ServeMux setting r.Pattern before it calls
+// the handler, so an empty pattern means the mux is answering itself.
+type unmatchedRouteWriter struct {
+	http.ResponseWriter
+	r        *http.Request
+	replaced bool
+}
+
+func (w *unmatchedRouteWriter) WriteHeader(status int) {
+	if w.r.Pattern != "" || (status != http.StatusNotFound && status != http.StatusMethodNotAllowed) {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+
+	w.replaced = true
+	body := errorResponse{Error: "Not found", Code: errCodeNotFound}
+	if status == http.StatusMethodNotAllowed {
+		body = errorResponse{Error: "Method not allowed", Code: errCodeMethodNotAllowed}
+	}
+	respondWithJSON(w.ResponseWriter, status, body)
+}
+
+func (w *unmatchedRouteWriter) Write(b []byte) (int, error) {
+	if w.replaced {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRespondWithError(t *testing.T) {
+	rec := httptest.NewRecorder()
+
+	respondWithError(rec, httptest.NewRequest(http.MethodGet, "/", nil), http.StatusConflict, errCodeConflict, "Email is already in use", nil)
+
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("Expected status %d, got %d", http.StatusConflict, rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("Expected JSON content type, got '%s'", ct)
+	}
+	body := errorResponse{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Expected JSON body, got %v", err)
+	}
+	if body.Error != "Email is already in use" || body.Code != errCodeConflict {
+		t.Fatalf("Unexpected error body: %+v", body)
+	}
+}
+
+func TestClientErrorsAreNotServerErrors(t *testing.T) {
+	cfg := newTestConfig(newFakeDB(t))
+
+	tests := []struct {
+		name       string
+		handler    http.HandlerFunc
+		request    func() *http.Request
+		wantStatus int
+		wantCode   string
+	}{
+		{
+			name:    "Malformed signup JSON",
+			handler: cfg.addUserHandler,
+			request: func() *http.Request {
+				return httptest.NewRequest(http.MethodPost, "/api/users", strings.NewReader("{not json"))
+			},
+			wantStatus: http.StatusBadRequest,
+			wantCode:   errCodeInvalidJSON,
+		},
+		{
+			name:    "Malformed login JSON",
+			handler: cfg.loginHandler,
+			request: func() *http.Request {
+				return httptest.NewRequest(http.MethodPost, "/api/login", strings.NewReader(""))
+			},
+			wantStatus: http.StatusBadRequest,
+			wantCode:   errCodeInvalidJSON,
+		},
+		{
+			name:    "Bad chirp UUID",
+			handler: cfg.getSingleChirpHandler,
+			request: func() *http.Request {
+				req := httptest.NewRequest(http.MethodGet, "/api/chirps/not-a-uuid", nil)
+				req.SetPathValue("chirpID", "not-a-uuid")
+				return req
+			},
+			wantStatus: http.StatusBadRequest,
+			wantCode:   errCodeInvalidID,
+		},
+		{
+			name:    "Bad list sort",
+			handler: cfg.getAllChirpsHandler,
+			request: func() *http.Request {
+				return httptest.NewRequest(http.MethodGet, "/api/chirps?sort=sideways", nil)
+			},
+			wantStatus: http.StatusBadRequest,
+			wantCode:   errCodeBadRequest,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+
+			tc.handler(rec, tc.request())
+
+			if rec.Code != tc.wantStatus {
+				t.Fatalf("Expected status %d, got %d", tc.wantStatus, rec.Code)
+			}
+			body := errorResponse{}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("Expected JSON error body, got %v", err)
+			}
+			if body.Code != tc.wantCode {
+				t.Fatalf("Expected code '%s', got '%s'", tc.wantCode, body.Code)
+			}
+		})
+	}
+}
+
+func TestUnmatchedRoutesReturnJSON(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	})
+	mux.HandleFunc("GET /api/chirps/{chirpID}", func(w http.ResponseWriter, r *http.Request) {
+		respondWithError(w, r, http.StatusNotFound, errCodeNotFound, "Chirp not found", nil)
+	})
+	mux.HandleFunc("GET /app/", http.NotFound)
+	handler := middlewareJSONErrors(mux)
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		wantStatus int
+		wantCode   string
+		wantError  string
+	}{
+		{name: "Unknown path", method: http.MethodGet, path: "/api/nope", wantStatus: http.StatusNotFound, wantCode: errCodeNotFound, wantError: "Not found"},
+		{name: "Wrong method", method: http.MethodDelete, path: "/api/healthz", wantStatus: http.StatusMethodNotAllowed, wantCode: errCodeMethodNotAllowed, wantError: "Method not allowed"},
+		{name: "Handler error kept", method: http.MethodGet, path: "/api/chirps/123", wantStatus: http.StatusNotFound, wantCode: errCodeNotFound, wantError: "Chirp not found"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(tc.method, tc.path, nil))
+			if rec.Code != tc.wantStatus {
+				t.Fatalf("Expected status %d, got %d", tc.wantStatus, rec.Code)
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+				t.Fatalf("Expected JSON content type, got '%s'", ct)
+			}
+			body := errorResponse{}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("Expected JSON error body, got %v: %q", err, rec.Body.String())
+			}
+			if body.Code != tc.wantCode || body.Error != tc.wantError {
+				t.Fatalf("Unexpected error body: %+v", body)
+			}
+		})
+	}
+
+	t.Run("Allow header kept", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/healthz", nil))
+		if allow := rec.Header().Get("Allow"); !strings.Contains(allow, http.MethodGet) {
+			t.Fatalf("Expected Allow to list GET, got '%s'", allow)
+		}
+	})
+
+	t.Run("Matched route responses untouched", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/app/missing", nil))
+		if rec.Code != http.StatusNotFound || strings.HasPrefix(rec.Body.String(), "{") {
+			t.Fatalf("Expected the static 404 to pass through, got %d %q", rec.Code, rec.Body.String())
+		}
+	})
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/YoavIsaacs/chirpy/internal/auth"
+	"github.com/YoavIsaacs/chirpy/internal/database"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+func TestLikeChirp(t *testing.T) {
+	userID := uuid.New()
+	chirpID := uuid.New()
+
+	db := newFakeDB(t)
+	var likes, unlikes [][]driver.NamedValue
+	db.on("LikeChirp", func(args []driver.NamedValue) fakeResult {
+		if args[1].Value != chirpID.String() {
+			return fakeResult{Err: &pq.Error{Code: "23503"}}
+		}
+		likes = append(likes, args)
+		if len(likes) > 1 {
+			// The primary key makes a repeated like insert nothing
+			return fakeResult{}
+		}
+		return fakeResult{RowsAffected: 1}
+	})
+	db.on("UnlikeChirp", func(args []driver.NamedValue) fakeResult {
+		unlikes = append(unlikes, args)
+		return fakeResult{}
+	})
+	cfg := newTestConfig(db)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/chirps/{chirpID}/likes", cfg.middlewareAuthenticate(cfg.likeChirpHandler))
+	mux.HandleFunc("DELETE /api/chirps/{chirpID}/likes", cfg.middlewareAuthenticate(cfg.unlikeChirpHandler))
+	token, err := auth.MakeJWT(userID, cfg.config.JWTSecret, time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to create token: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		chirpID    string
+		anonymous  bool
+		wantStatus int
+	}{
+		{name: "Like", method: http.MethodPost, chirpID: chirpID.String(), wantStatus: http.StatusNoContent},
+		{name: "Like again", method: http.MethodPost, chirpID: chirpID.String(), wantStatus: http.StatusNoContent},
+		{name: "Unknown chirp", method: http.MethodPost, chirpID: uuid.NewString(), wantStatus: http.StatusNotFound},
+		{name: "Invalid ID", method: http.MethodPost, chirpID: "not-a-uuid", wantStatus: http.StatusBadRequest},
+		{name: "Anonymous", method: http.MethodPost, chirpID: chirpID.String(), anonymous: true, wantStatus: http.StatusUnauthorized},
+		{name: "Unlike", method: http.MethodDelete, chirpID: chirpID.String(), wantStatus: http.StatusNoContent},
+		{name: "Unlike again", method: http.MethodDelete, chirpID: chirpID.String(), wantStatus: http.StatusNoContent},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, "/api/chirps/"+tc.chirpID+"/likes", nil)
+			if !tc.anonymous {
+				req.Header.Set("Authorization", "Bearer "+token)
+			}
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+			if rec.Code != tc.wantStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tc.wantStatus, rec.Code, rec.Body.String())
+			}
+		})
+	}
+
+	if len(likes) != 2 || likes[0][0].Value != userID.String() {
+		t.Fatalf("Expected two likes by the caller, got %v", likes)
+	}
+	if len(unlikes) != 2 || unlikes[0][0].Value != userID.String() || unlikes[0][1].Value != chirpID.String() {
+		t.Fatalf("Expected two unlikes by the caller, got %v", unlikes)
+	}
+}
+
+func TestLikedByMe(t *testing.T) {
+	viewerID := uuid.New()
+	liked := database.Chirp{ID: uuid.New(), Body: "liked", UserID: uuid.New(), LikeCount: 3}
+	notLiked := database.Chirp{ID: uuid.New(), Body: "not liked", UserID: uuid.New(), LikeCount: 1}
+
+	db := newFakeDB(t)
+	db.on("GetSingleChirp", chirpRow(liked))
+	db.on("ListChirpsAsc", func(args []driver.NamedValue) fakeResult {
+		rows := fakeResult{Columns: chirpColumns}
+		for _, c := range []database.Chirp{liked, notLiked} {
+			rows.Rows = append(rows.Rows, chirpRow(c)(args).Rows[0])
+		}
+		return rows
+	})
+	db.on("ListLikedChirpIDs", func(args []driver.NamedValue) fakeResult {
+		if args[0].Value != viewerID.String() {
+			t.Errorf("Expected likes of the viewer to be checked, got %v", args[0].Value)
+		}
+		return fakeResult{Columns: []string{"chirp_id"}, Rows: [][]driver.Value{{liked.ID.String()}}}
+	})
+	cfg := newTestConfig(db)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/chirps", cfg.middlewareOptionalAuthenticate(cfg.getAllChirpsHandler))
+	mux.HandleFunc("GET /api/chirps/{chirpID}", cfg.middlewareOptionalAuthenticate(cfg.getSingleChirpHandler))
+	token, err := auth.MakeJWT(viewerID, cfg.config.JWTSecret, time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to create token: %v", err)
+	}
+
+	get := func(target, authorization string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("Anonymous", func(t *testing.T) {
+		rec := get("/api/chirps/"+liked.ID.String(), "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d", rec.Code)
+		}
+		if !strings.Contains(rec.Body.String(), `"like_count":3,"liked_by_me":false`) {
+			t.Fatalf("Expected a like count and no like, got %s", rec.Body.String())
+		}
+		if slices.Contains(db.calls, "ListLikedChirpIDs") {
+			t.Fatal("Expected no like lookup for an anonymous caller")
+		}
+	})
+
+	t.Run("Single chirp", func(t *testing.T) {
+		rec := get("/api/chirps/"+liked.ID.String(), "Bearer "+token)
+		if !strings.Contains(rec.Body.String(), `"like_count":3,"liked_by_me":true`) {
+			t.Fatalf("Expected the viewer's like, got %s", rec.Body.String())
+		}
+	})
+
+	t.Run("All chirps", func(t *testing.T) {
+		rec := get("/api/chirps", "Bearer "+token)
+		page := struct {
+			Chirps []chirpResponse `json:"chirps"`
+		}{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil {
+			t.Fatalf("Expected JSON body, got %v", err)
+		}
+		if len(page.Chirps) != 2 || !page.Chirps[0].LikedByMe || page.Chirps[1].LikedByMe || page.Chirps[1].LikeCount != 1 {
+			t.Fatalf("Expected only the first chirp liked, got %+v", page.Chirps)
+		}
+	})
+
+	t.Run("Bad token", func(t *testing.T) {
+		if rec := get("/api/chirps", "Bearer expired"); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("Expected 401 for a bad token, got %d", rec.Code)
+		}
+	})
+}
+
+func TestUserLikes(t *testing.T) {
+	user := database.User{ID: uuid.New(), Email: "jesse@example.com"}
+	likedAt := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	likeColumns := append(append([]string{}, chirpColumns...), "liked_at")
+	like := func(id uuid.UUID, body string, at time.Time) []driver.Value {
+		return []driver.Value{id.String(), likedAt, likedAt, body, uuid.NewString(), int64(0), nil, int64(0), int64(1), at}
+	}
+	lastOnPage := uuid.New()
+
+	db := newFakeDB(t)
+	db.on("GetUserByID", func(args []driver.NamedValue) fakeResult {
+		if args[0].Value != user.ID.String() {
+			return fakeResult{Columns: userColumns}
+		}
+		return userRow(user)(args)
+	})
+	var params [][]driver.NamedValue
+	db.on("ListUserLikes", func(args []driver.NamedValue) fakeResult {
+		params = append(params, args)
+		return fakeResult{
+			Columns: likeColumns,
+			Rows: [][]driver.Value{
+				like(uuid.New(), "newest", likedAt.Add(2*time.Hour)),
+				like(lastOnPage, "older", likedAt.Add(time.Hour)),
+				like(uuid.New(), "oldest", likedAt),
+			},
+		}
+	})
+	cfg := newTestConfig(db)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/users/{userID}/likes", cfg.middlewareOptionalAuthenticate(cfg.getUserLikesHandler))
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/users/"+user.ID.String()+"/likes?limit=2", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var page struct {
+		Chirps []struct {
+			Body    string    `json:"body"`
+			LikedAt time.Time `json:"liked_at"`
+		} `json:"chirps"`
+		NextCursor string `json:"next_cursor"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil {
+		t.Fatalf("Expected JSON body, got %v", err)
+	}
+	if len(page.Chirps) != 2 || page.Chirps[0].Body != "newest" || !page.Chirps[1].LikedAt.Equal(likedAt.Add(time.Hour)) {
+		t.Fatalf("Expected the first page of likes, newest first, got %+v", page.Chirps)
+	}
+	if page.NextCursor != encodeCursor(likedAt.Add(time.Hour), lastOnPage) {
+		t.Fatalf("Expected a cursor after the last like, got %q", page.NextCursor)
+	}
+	if params[0][0].Value != user.ID.String() || params[0][3].Value != int64(3) {
+		t.Fatalf("Expected likes of the user with one extra row, got %v", params[0])
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/users/"+user.ID.String()+"/likes?cursor="+page.NextCursor, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if params[1][2].Value != lastOnPage.String() {
+		t.Fatalf("Expected the second page to resume after the cursor, got %v", params[1])
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/users/"+uuid.NewString()+"/likes", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("Expected 404 for an unknown user, got %d", rec.Code)
+	}
+}
+
+func TestLikedByMeInThreadAndRevisions(t *testing.T) {
+	viewerID := uuid.New()
+	postedAt := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	root := database.Chirp{ID: uuid.New(), CreatedAt: postedAt, Body: "root", UserID: uuid.New(), ReplyCount: 1, LikeCount: 1}
+	chirp := database.Chirp{ID: uuid.New(), CreatedAt: postedAt, Body: "chirp", UserID: uuid.New(), InReplyTo: uuid.NullUUID{UUID: root.ID, Valid: true}, RevisionCount: 1, LikeCount: 2}
+	replyID := uuid.New()
+
+	db := newFakeDB(t)
+	db.on("GetSingleChirp", chirpRow(chirp))
+	db.on("ListChirpAncestors", chirpRow(root))
+	db.on("ListChirpDescendants", func([]driver.NamedValue) fakeResult {
+		return fakeResult{
+			Columns: append(append([]string{}, chirpColumns...), "depth", "path"),
+			Rows:    [][]driver.Value{{replyID.String(), postedAt, postedAt, "reply", uuid.NewString(), int64(0), chirp.ID.String(), int64(0), int64(1), int64(1), "a"}},
+		}
+	})
+	db.on("ListChirpRevisions", func([]driver.NamedValue) fakeResult {
+		return fakeResult{
+			Columns: []string{"chirp_id", "revision", "body", "created_at"},
+			Rows:    [][]driver.Value{{chirp.ID.String(), int64(1), "first", postedAt}},
+		}
+	})
+	var checked [][]driver.NamedValue
+	db.on("ListLikedChirpIDs", func(args []driver.NamedValue) fakeResult {
+		checked = append(checked, args)
+		// The viewer liked the chirp and its reply but not the root
+		return fakeResult{Columns: []string{"chirp_id"}, Rows: [][]driver.Value{{chirp.ID.String()}, {replyID.String()}}}
+	})
+	cfg := newTestConfig(db)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/chirps/{chirpID}/thread", cfg.middlewareOptionalAuthenticate(cfg.getChirpThreadHandler))
+	mux.HandleFunc("GET /api/chirps/{chirpID}/revisions", cfg.middlewareOptionalAuthenticate(cfg.getChirpRevisionsHandler))
+	token, err := auth.MakeJWT(viewerID, cfg.config.JWTSecret, time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to create token: %v", err)
+	}
+	get := func(target string, authenticated bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		if authenticated {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected 200 for %s, got %d: %s", target, rec.Code, rec.Body.String())
+		}
+		return rec
+	}
+
+	t.Run("Thread", func(t *testing.T) {
+		var thread struct {
+			Ancestors []chirpResponse `json:"ancestors"`
+			Chirp     chirpResponse   `json:"chirp"`
+			Replies   []chirpResponse `json:"replies"`
+		}
+		rec := get("/api/chirps/"+chirp.ID.String()+"/thread", true)
+		if err := json.Unmarshal(rec.Body.Bytes(), &thread); err != nil {
+			t.Fatalf("Expected JSON body, got %v", err)
+		}
+		if len(thread.Ancestors) != 1 || thread.Ancestors[0].LikedByMe || !thread.Chirp.LikedByMe || len(thread.Replies) != 1 || !thread.Replies[0].LikedByMe {
+			t.Fatalf("Expected the chirp and its reply liked, got %s", rec.Body.String())
+		}
+		if len(checked) != 1 || checked[0][0].Value != viewerID.String() {
+			t.Fatalf("Expected one like lookup for the viewer, got %v", checked)
+		}
+	})
+
+	t.Run("Revisions", func(t *testing.T) {
+		var revisions struct {
+			Chirp chirpResponse `json:"chirp"`
+		}
+		rec := get("/api/chirps/"+chirp.ID.String()+"/revisions", true)
+		if err := json.Unmarshal(rec.Body.Bytes(), &revisions); err != nil {
+			t.Fatalf("Expected JSON body, got %v", err)
+		}
+		if !revisions.Chirp.LikedByMe || revisions.Chirp.LikeCount != 2 {
+			t.Fatalf("Expected the viewer's like, got %s", rec.Body.String())
+		}
+	})
+
+	t.Run("Anonymous", func(t *testing.T) {
+		checked = nil
+		for _, target := range []string{"/api/chirps/" + chirp.ID.String() + "/thread", "/api/chirps/" + chirp.ID.String() + "/revisions"} {
+			if body := get(target, false).Body.String(); strings.Contains(body, `"liked_by_me":true`) {
+				t.Fatalf("Expected nothing liked for an anonymous caller, got %s", body)
+			}
+		}
+		if checked != nil {
+			t.Fatal("Expected no like lookup for an anonymous caller")
+		}
+	})
+}
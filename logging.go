@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/YoavIsaacs/chirpy/internal/metrics"
+	"github.com/google/uuid"
+)
+
+const (
+	requestIDHeader    = "X-Request-ID"
+	maxRequestIDLength = 128
+)
+
+const loggerContextKey contextKey = "logger"
+
+// requestScope holds the logger for one request. Middlewares further down the
+// chain add attributes to it, e.g. the user ID once authenticated, and the
+// access log line picks them up.
+type requestScope struct {
+	mu     sync.Mutex
+	logger *slog.Logger
+}
+
+// requestLogger returns the logger for the request behind ctx, falling back
+// to the default logger outside a request.
+func requestLogger(ctx context.Context) *slog.Logger {
+	scope, ok := ctx.Value(loggerContextKey).(*requestScope)
+	if !ok {
+		return slog.Default()
+	}
+	scope.mu.Lock()
+	defer scope.mu.Unlock()
+	return scope.logger
+}
+
+// addLogAttrs attaches attributes to every later log line of the request.
+func addLogAttrs(ctx context.Context, args ...any) {
+	scope, ok := ctx.Value(loggerContextKey).(*requestScope)
+	if !ok {
+		return
+	}
+	scope.mu.Lock()
+	defer scope.mu.Unlock()
+	scope.logger = scope.logger.With(args...)
+}
+
+// validRequestID reports whether an incoming request ID is safe to propagate
+// into logs and response headers.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, ch := range id {
+		switch {
+		case ch >= 'a' && ch <= 'z', ch >= 'A' && ch <= 'Z', ch >= '0' && ch <= '9':
+		case ch == '-', ch == '_', ch == '.', ch == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// middlewareLogging assigns each request an ID, exposes a request-scoped
+// logger to handlers and writes one access log line per request. It must wrap
+// the ServeMux so the matched route pattern is known once the request is done.
+func (c *apiConfig) middlewareLogging(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(requestIDHeader)
+		if !validRequestID(requestID) {
+			requestID = uuid.NewString()
+		}
+		w.Header().Set(requestIDHeader, requestID)
+
+		scope := &requestScope{logger: c.logger.With("request_id", requestID)}
+		r = r.WithContext(context.WithValue(r.Context(), loggerContextKey, scope))
+
+		start := time.Now()
+		rec := metrics.NewResponseRecorder(w)
+		next.ServeHTTP(rec, r)
+
+		requestLogger(r.Context()).Info("request",
+			"method", r.Method,
+			"route", r.Pattern,
+			"path", r.URL.Path,
+			"status", rec.Status(),
+			"duration_ms", float64(time.Since(start).Microseconds())/1000,
+			"bytes", rec.Bytes(),
+		)
+	})
+}
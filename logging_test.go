@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/YoavIsaacs/chirpy/internal/auth"
+	"github.com/google/uuid"
+)
+
+func decodeLogLines(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var lines []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		entry := map[string]any{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("Expected JSON log line, got %q: %v", line, err)
+		}
+		lines = append(lines, entry)
+	}
+	return lines
+}
+
+func TestMiddlewareLogging(t *testing.T) {
+	var buf bytes.Buffer
+	cfg := newTestConfig(newFakeDB(t))
+	cfg.logger = slog.New(slog.NewJSONHandler(&buf, nil))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /things/{id}", cfg.middlewareAuthenticate(func(w http.ResponseWriter, r *http.Request) {
+		respondWithError(w, r, http.StatusInternalServerError, errCodeInternal, "Couldn't do thing", http.ErrAbortHandler)
+	}))
+	handler := cfg.middlewareLogging(mux)
+
+	userID := uuid.New()
+	token, err := auth.MakeJWT(userID, cfg.config.JWTSecret, time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to make token: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/things/42", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set(requestIDHeader, "upstream-id.1")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if got := rec.Header().Get(requestIDHeader); got != "upstream-id.1" {
+		t.Fatalf("Expected incoming request ID to be propagated, got '%s'", got)
+	}
+
+	lines := decodeLogLines(t, &buf)
+	if len(lines) != 2 {
+		t.Fatalf("Expected an error line and an access line, got %d: %s", len(lines), buf.String())
+	}
+	errLine, access := lines[0], lines[1]
+	if errLine["level"] != "ERROR" || errLine["msg"] != "Couldn't do thing" || errLine["request_id"] != "upstream-id.1" || errLine["user_id"] != userID.String() {
+		t.Fatalf("Unexpected error log line: %v", errLine)
+	}
+	for key, want := range map[string]any{
+		"msg":        "request",
+		"request_id": "upstream-id.1",
+		"user_id":    userID.String(),
+		"method":     "POST",
+		"route":      "POST /things/{id}",
+		"path":       "/things/42",
+		"status":     float64(http.StatusInternalServerError),
+		"bytes":      float64(rec.Body.Len()),
+	} {
+		if access[key] != want {
+			t.Fatalf("Expected access log %s=%v, got %v (line: %v)", key, want, access[key], access)
+		}
+	}
+	if _, ok := access["duration_ms"].(float64); !ok {
+		t.Fatalf("Expected numeric duration_ms, got %v", access["duration_ms"])
+	}
+}
+
+func TestMiddlewareLoggingGeneratesRequestID(t *testing.T) {
+	var buf bytes.Buffer
+	cfg := newTestConfig(newFakeDB(t))
+	cfg.logger = slog.New(slog.NewJSONHandler(&buf, nil))
+	handler := cfg.middlewareLogging(http.NewServeMux())
+
+	for _, incoming := range []string{"", "has spaces", "bad\nnewline", strings.Repeat("a", maxRequestIDLength+1)} {
+		buf.Reset()
+		req := httptest.NewRequest(http.MethodGet, "/missing", nil)
+		if incoming != "" {
+			req.Header.Set(requestIDHeader, incoming)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		got := rec.Header().Get(requestIDHeader)
+		if _, err := uuid.Parse(got); err != nil {
+			t.Fatalf("Expected generated UUID request ID for %q, got '%s'", incoming, got)
+		}
+		access := decodeLogLines(t, &buf)[0]
+		if access["request_id"] != got || access["status"] != float64(http.StatusNotFound) {
+			t.Fatalf("Unexpected access log line: %v", access)
+		}
+		if _, ok := access["user_id"]; ok {
+			t.Fatalf("Expected no user_id for anonymous request, got %v", access["user_id"])
+		}
+	}
+}
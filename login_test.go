@@ -0,0 +1,204 @@
+package main
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/YoavIsaacs/chirpy/internal/auth"
+	"github.com/YoavIsaacs/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+func loginRequest(email, password string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/api/login", strings.NewReader(`{"email":"`+email+`","password":"`+password+`"}`))
+	req.RemoteAddr = "192.0.2.10:5555"
+	return req
+}
+
+func TestLoginFailuresAreUniform(t *testing.T) {
+	hashed, err := auth.HashPassword("correct-horse")
+	if err != nil {
+		t.Fatalf("Failed to hash password: %v", err)
+	}
+	user := database.User{ID: uuid.New(), Email: "skyler@example.com", HashedPassword: hashed}
+
+	db := newFakeDB(t)
+	db.on("GetLoginFailure", noLoginFailures)
+	db.on("GetUserByEmail", func(args []driver.NamedValue) fakeResult {
+		if args[0].Value == user.Email {
+			return userRow(user)(args)
+		}
+		return fakeResult{Columns: userColumns}
+	})
+	var mu sync.Mutex
+	var recorded []string
+	db.on("RecordLoginFailure", func(args []driver.NamedValue) fakeResult {
+		mu.Lock()
+		recorded = append(recorded, args[0].Value.(string))
+		mu.Unlock()
+		return fakeResult{Columns: loginFailureColumns, Rows: [][]driver.Value{{args[0].Value, int64(1), args[1].Value}}}
+	})
+	cfg := newTestConfig(db)
+
+	var bodies []string
+	for _, email := range []string{user.Email, "nobody@example.com"} {
+		rec := httptest.NewRecorder()
+		cfg.loginHandler(rec, loginRequest(email, "wrong-password"))
+		if rec.Code != http.StatusUnauthorized {
+			t.Fatalf("Expected 401 for %s, got %d", email, rec.Code)
+		}
+		bodies = append(bodies, rec.Body.String())
+	}
+	if bodies[0] != bodies[1] {
+		t.Fatalf("Expected identical responses for unknown user and wrong password, got %q and %q", bodies[0], bodies[1])
+	}
+
+	want := []string{"account:skyler@example.com", "ip:192.0.2.10", "account:nobody@example.com", "ip:192.0.2.10"}
+	if !slices.Equal(recorded, want) {
+		t.Fatalf("Expected failures recorded for %v, got %v", want, recorded)
+	}
+	if got := cfg.metrics.logins.Value("failure"); got != 2 {
+		t.Fatalf("Expected 2 failed logins counted, got %v", got)
+	}
+}
+
+func TestLoginThrottled(t *testing.T) {
+	tests := []struct {
+		name        string
+		key         string
+		failures    int64
+		ago         time.Duration
+		wantStatus  int
+		wantRetryIn string
+	}{
+		{name: "Free attempts", key: "account:", failures: 3, ago: 0, wantStatus: http.StatusUnauthorized},
+		{name: "Progressive delay", key: "account:", failures: 4, ago: 200 * time.Millisecond, wantStatus: http.StatusTooManyRequests, wantRetryIn: "1"},
+		{name: "Delay elapsed", key: "account:", failures: 4, ago: time.Minute, wantStatus: http.StatusUnauthorized},
+		{name: "Account locked", key: "account:", failures: 5, ago: 5 * time.Minute, wantStatus: http.StatusTooManyRequests, wantRetryIn: "600"},
+		{name: "Lock expired", key: "account:", failures: 5, ago: 20 * time.Minute, wantStatus: http.StatusUnauthorized},
+		{name: "IP locked", key: "ip:", failures: 50, ago: time.Minute, wantStatus: http.StatusTooManyRequests, wantRetryIn: "840"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			lastFailed := time.Now().UTC().Add(-tc.ago)
+			db := newFakeDB(t)
+			db.on("GetLoginFailure", func(args []driver.NamedValue) fakeResult {
+				key := args[0].Value.(string)
+				if !strings.HasPrefix(key, tc.key) {
+					return noLoginFailures(args)
+				}
+				return fakeResult{Columns: loginFailureColumns, Rows: [][]driver.Value{{key, tc.failures, lastFailed}}}
+			})
+			db.on("GetUserByEmail", func([]driver.NamedValue) fakeResult { return fakeResult{Columns: userColumns} })
+			db.on("RecordLoginFailure", func(args []driver.NamedValue) fakeResult {
+				return fakeResult{Columns: loginFailureColumns, Rows: [][]driver.Value{{args[0].Value, tc.failures + 1, args[1].Value}}}
+			})
+			cfg := newTestConfig(db)
+
+			rec := httptest.NewRecorder()
+			cfg.loginHandler(rec, loginRequest("jesse@example.com", "guess"))
+			if rec.Code != tc.wantStatus {
+				t.Fatalf("Expected status %d, got %d", tc.wantStatus, rec.Code)
+			}
+			if got := rec.Header().Get("Retry-After"); got != tc.wantRetryIn {
+				t.Fatalf("Expected Retry-After %q, got %q", tc.wantRetryIn, got)
+			}
+			if tc.wantStatus == http.StatusTooManyRequests && slices.Contains(db.calls, "GetUserByEmail") {
+				t.Fatal("Expected throttled login not to check the password")
+			}
+		})
+	}
+}
+
+func TestLoginSuccessClearsAccountFailures(t *testing.T) {
+	hashed, err := auth.HashPassword("correct-horse")
+	if err != nil {
+		t.Fatalf("Failed to hash password: %v", err)
+	}
+	user := database.User{ID: uuid.New(), Email: "skyler@example.com", HashedPassword: hashed}
+
+	db := newFakeDB(t)
+	lastFailed := time.Now().UTC().Add(-time.Second)
+	db.on("GetLoginFailure", func(args []driver.NamedValue) fakeResult {
+		return fakeResult{Columns: loginFailureColumns, Rows: [][]driver.Value{{args[0].Value, int64(2), lastFailed}}}
+	})
+	db.on("GetUserByEmail", userRow(user))
+	var cleared []string
+	db.on("ClearLoginFailures", func(args []driver.NamedValue) fakeResult {
+		cleared = append(cleared, args[0].Value.(string))
+		return fakeResult{}
+	})
+	db.on("CreateRefreshToken", func(args []driver.NamedValue) fakeResult {
+		return fakeResult{
+			Columns: []string{"token", "created_at", "updated_at", "user_id", "expires_at", "revoked_at", "replaced_by"},
+			Rows:    [][]driver.Value{{args[0].Value, time.Now(), time.Now(), user.ID.String(), time.Now(), nil, nil}},
+		}
+	})
+	cfg := newTestConfig(db)
+
+	rec := httptest.NewRecorder()
+	cfg.loginHandler(rec, loginRequest(" Skyler@Example.com", "correct-horse"))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if !slices.Equal(cleared, []string{"account:skyler@example.com"}) {
+		t.Fatalf("Expected only the account failures to be cleared, got %v", cleared)
+	}
+}
+
+func TestUnlockUser(t *testing.T) {
+	user := database.User{ID: uuid.New(), Email: "Marie@Example.com"}
+
+	db := newFakeDB(t)
+	db.on("GetUserByID", func(args []driver.NamedValue) fakeResult {
+		if args[0].Value == user.ID.String() {
+			return userRow(user)(args)
+		}
+		return fakeResult{Columns: userColumns}
+	})
+	var cleared string
+	db.on("ClearLoginFailures", func(args []driver.NamedValue) fakeResult {
+		cleared = args[0].Value.(string)
+		return fakeResult{}
+	})
+	cfg := newTestConfig(db)
+	cfg.config.AdminAPIKey = "admin-key"
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /admin/users/{userID}/unlock", cfg.middlewareAdmin(cfg.unlockUserHandler))
+
+	tests := []struct {
+		name       string
+		userID     string
+		apiKey     string
+		wantStatus int
+	}{
+		{name: "Missing key", userID: user.ID.String(), wantStatus: http.StatusUnauthorized},
+		{name: "Invalid ID", userID: "not-a-uuid", apiKey: "admin-key", wantStatus: http.StatusBadRequest},
+		{name: "Unknown user", userID: uuid.NewString(), apiKey: "admin-key", wantStatus: http.StatusNotFound},
+		{name: "Unlocked", userID: user.ID.String(), apiKey: "admin-key", wantStatus: http.StatusNoContent},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/admin/users/"+tc.userID+"/unlock", nil)
+			if tc.apiKey != "" {
+				req.Header.Set("Authorization", "ApiKey "+tc.apiKey)
+			}
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+			if rec.Code != tc.wantStatus {
+				t.Fatalf("Expected status %d, got %d", tc.wantStatus, rec.Code)
+			}
+		})
+	}
+	if cleared != "account:marie@example.com" {
+		t.Fatalf("Expected the account key to be cleared, got %q", cleared)
+	}
+}
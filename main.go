@@ -1,73 +1,296 @@
 package main
 
 import (
+	"context"
 	"database/sql"
-	"encoding/json"
+	"embed"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"io/fs"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/YoavIsaacs/chirpy/internal/auth"
+	"github.com/YoavIsaacs/chirpy/internal/chirptext"
+	"github.com/YoavIsaacs/chirpy/internal/config"
 	"github.com/YoavIsaacs/chirpy/internal/database"
+	"github.com/YoavIsaacs/chirpy/internal/email"
+	"github.com/YoavIsaacs/chirpy/internal/metrics"
+	"github.com/YoavIsaacs/chirpy/internal/migrate"
+	"github.com/YoavIsaacs/chirpy/internal/moderation"
+	"github.com/YoavIsaacs/chirpy/internal/ratelimit"
+	"github.com/YoavIsaacs/chirpy/internal/static"
 	"github.com/google/uuid"
-	"github.com/joho/godotenv"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
+//go:embed index.html assets
+var embeddedStatic embed.FS
+
+//go:embed sql/schema/*.sql
+var embeddedMigrations embed.FS
+
+const staticMaxAge = time.Hour
+
+// staticFS returns the files served under /app/. Only the embedded site is
+// served unless an explicit directory is configured.
+func staticFS(dir string) fs.FS {
+	if dir != "" {
+		return os.DirFS(dir)
+	}
+	return embeddedStatic
+}
+
+func migrationsFS() fs.FS {
+	fsys, err := fs.Sub(embeddedMigrations, "sql/schema")
+	if err != nil {
+		// The directory is fixed at compile time by the embed directive
+		panic(err)
+	}
+	return fsys
+}
+
 type apiConfig struct {
-	fileserverHits atomic.Int32
-	database       *database.Queries
+	draining      atomic.Bool
+	db            *sql.DB
+	database      *database.Queries
+	config        config.Config
+	contentFilter moderation.Filter
+	metrics       *appMetrics
+	logger        *slog.Logger
+	rateLimiter   *ratelimit.Limiter
+	mailer        email.Mailer
+
+	// background tracks work that outlives its request, e.g. emails sent
+	// after responding, so shutdown can wait for it. backgroundMu orders
+	// starting such work against draining being set, so nothing is added to
+	// the group once shutdown may be waiting on it.
+	background   sync.WaitGroup
+	backgroundMu sync.Mutex
+}
+
+// appMetrics holds every metric the server exports. The /admin/metrics page
+// reads from the same registry that /metrics exposes.
+type appMetrics struct {
+	registry       *metrics.Registry
+	http           *metrics.HTTPMetrics
+	fileserverHits *metrics.CounterVec
+	chirpsCreated  *metrics.CounterVec
+	logins         *metrics.CounterVec
+}
+
+func newAppMetrics(db *sql.DB) *appMetrics {
+	registry := metrics.NewRegistry()
+	m := &appMetrics{
+		registry:       registry,
+		http:           metrics.NewHTTPMetrics(registry),
+		fileserverHits: registry.NewCounter("chirpy_fileserver_hits_total", "Requests served under /app/."),
+		chirpsCreated:  registry.NewCounter("chirpy_chirps_created_total", "Chirps created."),
+		logins:         registry.NewCounterVec("chirpy_logins_total", "Login attempts by result.", "result"),
+	}
+
+	gauges := []struct {
+		name, help string
+		value      func(sql.DBStats) float64
+	}{
+		{"chirpy_db_max_open_connections", "Maximum number of open database connections.", func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) }},
+		{"chirpy_db_open_connections", "Established database connections, in use and idle.", func(s sql.DBStats) float64 { return float64(s.OpenConnections) }},
+		{"chirpy_db_in_use_connections", "Database connections currently in use.", func(s sql.DBStats) float64 { return float64(s.InUse) }},
+		{"chirpy_db_idle_connections", "Idle database connections.", func(s sql.DBStats) float64 { return float64(s.Idle) }},
+	}
+	for _, g := range gauges {
+		registry.NewGaugeFunc(g.name, g.help, func() float64 { return g.value(db.Stats()) })
+	}
+
+	counters := []struct {
+		name, help string
+		value      func(sql.DBStats) float64
+	}{
+		{"chirpy_db_wait_count_total", "Times a query waited for a database connection.", func(s sql.DBStats) float64 { return float64(s.WaitCount) }},
+		{"chirpy_db_wait_duration_seconds_total", "Time spent waiting for database connections.", func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() }},
+		{"chirpy_db_max_idle_closed_total", "Connections closed due to the idle connection limit.", func(s sql.DBStats) float64 { return float64(s.MaxIdleClosed) }},
+		{"chirpy_db_max_lifetime_closed_total", "Connections closed due to their maximum lifetime.", func(s sql.DBStats) float64 { return float64(s.MaxLifetimeClosed) }},
+	}
+	for _, c := range counters {
+		registry.NewCounterFunc(c.name, c.help, func() float64 { return c.value(db.Stats()) })
+	}
+
+	// Pre-create the success, failure and throttled results so dashboards see
+	// zeros rather than gaps
+	for _, result := range []string{"success", "failure", "throttled"} {
+		m.logins.Add(0, result)
+	}
+	return m
+}
+
+type contextKey string
+
+const userIDContextKey contextKey = "userID"
+
+func (c *apiConfig) middlewareAuthenticate(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, err := auth.GetBearerToken(r.Header)
+		if err != nil {
+			respondWithError(w, r, http.StatusUnauthorized, errCodeUnauthorized, "Missing or malformed bearer token", err)
+			return
+		}
+
+		userID, err := auth.ValidateJWT(token, c.config.JWTSecret)
+		if err != nil {
+			respondWithError(w, r, http.StatusUnauthorized, errCodeUnauthorized, "Invalid or expired token", err)
+			return
+		}
+
+		addLogAttrs(r.Context(), "user_id", userID)
+		ctx := context.WithValue(r.Context(), userIDContextKey, userID)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	}
+}
+
+// middlewareOptionalAuthenticate identifies callers that send a bearer token
+// and lets anonymous requests through. A bad token is still rejected so the
+// client finds out it has expired.
+func (c *apiConfig) middlewareOptionalAuthenticate(next http.HandlerFunc) http.HandlerFunc {
+	authenticated := c.middlewareAuthenticate(next)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		authenticated.ServeHTTP(w, r)
+	}
+}
+
+func (c *apiConfig) middlewareAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := auth.CheckAPIKey(r.Header, c.config.AdminAPIKey)
+		if err != nil {
+			respondWithError(w, r, http.StatusUnauthorized, errCodeUnauthorized, "Invalid admin credentials", err)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
+// middlewareRateLimit applies the limit configured for the matched route.
+// Callers are keyed by user ID when an earlier middleware authenticated them
+// and by IP otherwise.
+func (c *apiConfig) middlewareRateLimit(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := "ip:" + c.clientIP(r)
+		if userID, ok := userIDFromContext(r.Context()); ok {
+			key = "user:" + userID.String()
+		}
+
+		res, limited, err := c.rateLimiter.Allow(r.Context(), r.Pattern, key)
+		if err != nil {
+			// Fail open so an unavailable store cannot take the API down
+			requestLogger(r.Context()).Error("error checking rate limit", "error", err)
+			next(w, r)
+			return
+		}
+		if !limited {
+			next(w, r)
+			return
+		}
+
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(res.Limit))
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		w.Header().Set("X-RateLimit-Reset", strconv.Itoa(ceilSeconds(res.ResetAfter)))
+		if !res.Allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(res.RetryAfter)))
+			respondWithError(w, r, http.StatusTooManyRequests, errCodeRateLimited, "Too many requests", nil)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
+}
+
+// clientIP returns the address of the caller. X-Forwarded-For is only
+// honoured behind a trusted proxy, and then only the entry that proxy added.
+func (c *apiConfig) clientIP(r *http.Request) string {
+	if c.config.TrustProxyHeaders {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			hops := strings.Split(forwarded, ",")
+			return strings.TrimSpace(hops[len(hops)-1])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func userIDFromContext(ctx context.Context) (uuid.UUID, bool) {
+	userID, ok := ctx.Value(userIDContextKey).(uuid.UUID)
+	return userID, ok
 }
 
 func (c *apiConfig) middlewareMetricsInc(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		c.fileserverHits.Add(1)
+		c.metrics.fileserverHits.Inc()
 		next.ServeHTTP(w, r)
 	})
 }
 
+func (c *apiConfig) appHandler(fsys fs.FS) http.Handler {
+	return c.middlewareMetricsInc(http.StripPrefix("/app", static.NewHandler(fsys, staticMaxAge)))
+}
+
 func (c *apiConfig) metricsHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		w.WriteHeader(405)
-	} else {
-		w.Header().Add("Content-Type", "text/html; charset=utf-8")
-		w.WriteHeader(200)
-		serverHits := c.fileserverHits.Load()
-		output := fmt.Sprintf(
-			`<html>
+	w.Header().Add("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(200)
+	serverHits := int64(c.metrics.fileserverHits.Value())
+	output := fmt.Sprintf(
+		`<html>
       <body>
         <h1>Welcome, Chirpy Admin</h1>
         <p>Chirpy has been visited %d times!</p>
       </body>
     </html>`,
-			serverHits,
-		)
+		serverHits,
+	)
 
-		w.Write([]byte(output))
-	}
+	w.Write([]byte(output))
 }
 
 func (c *apiConfig) resetHandler(w http.ResponseWriter, r *http.Request) {
-	isDev := os.Getenv("PLATFORM") == "dev"
-	if r.Method != http.MethodPost {
-		w.WriteHeader(405)
-		fmt.Println(r.Method)
-	} else if !isDev {
-		w.WriteHeader(http.StatusForbidden)
+	isDev := c.config.IsDev()
+	if !isDev {
+		respondWithError(w, r, http.StatusForbidden, errCodeForbidden, "Reset is only allowed in dev", nil)
 	} else {
-		c.fileserverHits.Store(0)
-		c.database.ResetUsers(r.Context())
+		err := c.database.ResetUsers(r.Context())
+		if err != nil {
+			respondWithError(w, r, http.StatusInternalServerError, errCodeInternal, "Couldn't reset users", err)
+			return
+		}
+		c.metrics.fileserverHits.Reset()
 		w.Header().Add("Content-Type", "text/plain; charset=utf-8")
 		w.WriteHeader(200)
 		w.Write([]byte("Hits reset to 0"))
 	}
 }
 
-func healthCheckHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		w.WriteHeader(405)
+func (c *apiConfig) healthCheckHandler(w http.ResponseWriter, r *http.Request) {
+	if c.draining.Load() {
+		w.Header().Add("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("Shutting down"))
 	} else {
 		w.Header().Add("Content-Type", "text/plain; charset=utf-8")
 		w.WriteHeader(200)
@@ -75,6 +298,110 @@ func healthCheckHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+const migrationVersionQuery = `SELECT COALESCE(MAX(version_id), 0) FROM goose_db_version WHERE is_applied`
+
+func (c *apiConfig) readinessHandler(w http.ResponseWriter, r *http.Request) {
+	type componentStatus struct {
+		Status    string `json:"status"`
+		LatencyMS int64  `json:"latency_ms,omitempty"`
+		Version   *int64 `json:"version,omitempty"`
+		Error     string `json:"error,omitempty"`
+	}
+
+	type readinessResponse struct {
+		Status     string                     `json:"status"`
+		Components map[string]componentStatus `json:"components"`
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), c.config.ReadinessTimeout)
+	defer cancel()
+
+	ready := !c.draining.Load()
+	resp := readinessResponse{
+		Components: map[string]componentStatus{},
+	}
+	if !ready {
+		resp.Components["server"] = componentStatus{Status: "down", Error: "shutting down"}
+	}
+
+	start := time.Now()
+	err := c.db.PingContext(ctx)
+	dbStatus := componentStatus{Status: "up", LatencyMS: time.Since(start).Milliseconds()}
+	if err != nil {
+		// Driver errors can name internal hosts and roles, so they only go to the log
+		requestLogger(r.Context()).Error("readiness: database ping failed", "error", err)
+		ready = false
+		dbStatus.Status = "down"
+		dbStatus.Error = "database unreachable"
+	}
+	resp.Components["database"] = dbStatus
+
+	// Migration status is informational; an unknown version does not fail readiness
+	if err == nil {
+		var version int64
+		err = c.db.QueryRowContext(ctx, migrationVersionQuery).Scan(&version)
+		if err != nil {
+			requestLogger(r.Context()).Warn("readiness: couldn't read migration version", "error", err)
+			resp.Components["migrations"] = componentStatus{Status: "unknown", Error: "migration version unavailable"}
+		} else {
+			resp.Components["migrations"] = componentStatus{Status: "up", Version: &version}
+		}
+	}
+
+	status := http.StatusOK
+	resp.Status = "ready"
+	if !ready {
+		status = http.StatusServiceUnavailable
+		resp.Status = "not_ready"
+	}
+	respondWithJSON(w, status, resp)
+}
+
+// waitForDatabase pings db with exponential backoff until it answers or the
+// timeout passes, so the server never accepts traffic it cannot serve.
+func waitForDatabase(ctx context.Context, db *sql.DB, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	backoff := 100 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		err := db.PingContext(ctx)
+		if err == nil {
+			return nil
+		}
+		slog.Warn("waiting for database", "attempt", attempt, "error", err)
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("database not reachable after %s: %w", timeout, err)
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, 2*time.Second)
+	}
+}
+
+// userResponse is the public representation of a user shared by every user
+// route. It intentionally has no field for the password hash.
+type userResponse struct {
+	ID            uuid.UUID `json:"id"`
+	Created_at    time.Time `json:"created_at"`
+	Updated_at    time.Time `json:"updated_at"`
+	Email         string    `json:"email"`
+	EmailVerified bool      `json:"email_verified"`
+	IsChirpyRed   bool      `json:"is_chirpy_red"`
+}
+
+func newUserResponse(user database.User) userResponse {
+	return userResponse{
+		ID:            user.ID,
+		Created_at:    user.CreatedAt,
+		Updated_at:    user.UpdatedAt,
+		Email:         user.Email,
+		EmailVerified: user.VerifiedAt.Valid,
+		IsChirpyRed:   user.IsChirpyRed,
+	}
+}
+
 func (c *apiConfig) addUserHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	type paramsSent struct {
@@ -82,322 +409,1812 @@ func (c *apiConfig) addUserHandler(w http.ResponseWriter, r *http.Request) {
 		Password string `json:"password"`
 	}
 
-	type responseLower struct {
-		ID         uuid.UUID `json:"id"`
-		Created_at time.Time `json:"created_at"`
-		Updated_at time.Time `json:"updated_at"`
-		Email      string    `json:"email"`
-		Password   string    `json:"password"`
-	}
-
-	decoder := json.NewDecoder(r.Body)
 	paramsDecoded := paramsSent{}
-	err := decoder.Decode(&paramsDecoded)
+	err := decodeJSON(r, &paramsDecoded)
+	if err != nil {
+		respondWithError(w, r, http.StatusBadRequest, errCodeInvalidJSON, "Invalid JSON body", err)
+		return
+	}
+	address, err := email.Normalize(paramsDecoded.Email)
 	if err != nil {
-		fmt.Printf("error: error decoding json: %s", err)
-		w.WriteHeader(500)
+		respondWithError(w, r, http.StatusUnprocessableEntity, errCodeUnprocessable, "Invalid email address", err)
 		return
 	}
 	hashed, err := auth.HashPassword(paramsDecoded.Password)
 	if err != nil {
-		fmt.Printf("error: error decoding json: %s", err)
-		w.WriteHeader(500)
+		respondWithError(w, r, http.StatusInternalServerError, errCodeInternal, "Couldn't hash password", err)
 		return
 	}
 	params := database.CreateUserParams{
-		Email:          paramsDecoded.Email,
+		Email:          address,
 		HashedPassword: hashed,
 	}
 	createdUsr, err := c.database.CreateUser(ctx, params)
 	if err != nil {
-		fmt.Printf("error: error creating new user: %s", err)
-		w.WriteHeader(500)
+		if isUniqueViolation(err) {
+			respondWithError(w, r, http.StatusConflict, errCodeConflict, "Email is already in use", err)
+			return
+		}
+		respondWithError(w, r, http.StatusInternalServerError, errCodeInternal, "Couldn't create user", err)
 		return
 	}
 
-	userResp := responseLower{
-		ID:         createdUsr.ID,
-		Created_at: createdUsr.CreatedAt,
-		Updated_at: createdUsr.UpdatedAt,
-		Email:      createdUsr.Email,
-		Password:   createdUsr.HashedPassword,
+	// The account exists either way; the user can ask for a new token from
+	// POST /api/users/verify/resend
+	err = c.sendVerificationEmail(ctx, createdUsr)
+	if err != nil {
+		requestLogger(ctx).Error("error sending verification email", "user_id", createdUsr.ID, "error", err)
 	}
 
-	responseData, err := json.Marshal(userResp)
+	respondWithJSON(w, http.StatusCreated, newUserResponse(createdUsr))
+}
+
+func (c *apiConfig) sendVerificationEmail(ctx context.Context, user database.User) error {
+	token, err := auth.MakeOneTimeToken()
 	if err != nil {
-		fmt.Printf("error: error decoding response: %s", err)
-		w.WriteHeader(500)
-		return
+		return err
+	}
+
+	err = c.database.CreateEmailVerificationToken(ctx, database.CreateEmailVerificationTokenParams{
+		TokenHash: auth.HashToken(token),
+		UserID:    user.ID,
+		Email:     user.Email,
+		ExpiresAt: time.Now().UTC().Add(c.config.EmailVerificationTTL),
+	})
+	if err != nil {
+		return fmt.Errorf("error storing verification token: %w", err)
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	w.Write(responseData)
+	return c.mailer.Send(ctx, email.Message{
+		To:      user.Email,
+		Subject: "Verify your Chirpy email address",
+		Body: fmt.Sprintf("Confirm this is your email address by sending this token to POST /api/users/verify:\n\n    %s\n\nIt expires in %s. If you did not sign up for Chirpy, ignore this email.\n",
+			token, c.config.EmailVerificationTTL),
+	})
 }
 
-func (c *apiConfig) getAllChirpsHandler(w http.ResponseWriter, r *http.Request) {
-	type createdChirpLower struct {
-		ID         uuid.UUID `json:"id"`
-		Created_at time.Time `json:"created_at"`
-		Updated_at time.Time `json:"updated_at"`
-		Body       string    `json:"body"`
-		User_id    uuid.UUID `json:"user_id"`
+func (c *apiConfig) verifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	type paramsSent struct {
+		Token string `json:"token"`
 	}
 
-	retValue := []createdChirpLower{}
+	paramsDecoded := paramsSent{}
+	err := decodeJSON(r, &paramsDecoded)
+	if err != nil {
+		respondWithError(w, r, http.StatusBadRequest, errCodeInvalidJSON, "Invalid JSON body", err)
+		return
+	}
+	if paramsDecoded.Token == "" {
+		respondWithError(w, r, http.StatusBadRequest, errCodeBadRequest, "Missing verification token", nil)
+		return
+	}
 
-	resp, err := c.database.GetAllChirps(r.Context())
+	tx, err := c.db.BeginTx(ctx, nil)
 	if err != nil {
-		fmt.Printf("error: error getting all chirps: %s", err)
-		w.WriteHeader(500)
+		respondWithError(w, r, http.StatusInternalServerError, errCodeInternal, "Couldn't verify user", err)
 		return
 	}
+	defer tx.Rollback()
+	queries := c.database.WithTx(tx)
 
-	temp := createdChirpLower{}
-	for _, chirp := range resp {
-		temp.ID = chirp.ID
-		temp.Created_at = chirp.CreatedAt
-		temp.Updated_at = chirp.UpdatedAt
-		temp.Body = chirp.Body
-		temp.User_id = chirp.UserID
+	token, err := queries.ConsumeEmailVerificationToken(ctx, auth.HashToken(paramsDecoded.Token))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithError(w, r, http.StatusBadRequest, errCodeBadRequest, "Invalid or expired verification token", nil)
+			return
+		}
+		respondWithError(w, r, http.StatusInternalServerError, errCodeInternal, "Couldn't check verification token", err)
+		return
+	}
 
-		retValue = append(retValue, temp)
+	// The token only proves ownership of the address it was mailed to, so it
+	// is void once the account has moved to another one
+	user, err := queries.MarkUserVerified(ctx, database.MarkUserVerifiedParams{
+		ID:    token.UserID,
+		Email: token.Email,
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithError(w, r, http.StatusBadRequest, errCodeBadRequest, "Invalid or expired verification token", nil)
+			return
+		}
+		respondWithError(w, r, http.StatusInternalServerError, errCodeInternal, "Couldn't verify user", err)
+		return
 	}
-	responseData, err := json.Marshal(retValue)
+	err = tx.Commit()
 	if err != nil {
-		fmt.Printf("error: error marshalling chirps: %s", err)
-		w.WriteHeader(500)
+		respondWithError(w, r, http.StatusInternalServerError, errCodeInternal, "Couldn't verify user", err)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	w.Write(responseData)
+	respondWithJSON(w, http.StatusOK, newUserResponse(user))
 }
 
-func (c *apiConfig) getSingleChirpHandler(w http.ResponseWriter, r *http.Request) {
-	type createdChirpLower struct {
-		ID         uuid.UUID `json:"id"`
-		Created_at time.Time `json:"created_at"`
-		Updated_at time.Time `json:"updated_at"`
-		Body       string    `json:"body"`
-		User_id    uuid.UUID `json:"user_id"`
-	}
-
-	queryIDstr := r.PathValue("chirpID")
-
-	queryID, err := uuid.Parse(queryIDstr)
-	if err != nil {
-		fmt.Printf("error: error converting ID: %s", err)
-		w.WriteHeader(500)
+// resendVerificationHandler mails the caller a new verification token, e.g.
+// when the first email never arrived or its token expired. Earlier tokens
+// keep working until they expire.
+func (c *apiConfig) resendVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDFromContext(r.Context())
+	if !ok {
+		respondWithError(w, r, http.StatusUnauthorized, errCodeUnauthorized, "Not authenticated", nil)
 		return
 	}
 
-	chirp, err := c.database.GetSingleChirp(r.Context(), queryID)
+	user, err := c.database.GetUserByID(r.Context(), userID)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			fmt.Printf("error: error finding chirp with this ID: %s", err)
-			w.WriteHeader(http.StatusNotFound)
+			respondWithError(w, r, http.StatusNotFound, errCodeNotFound, "User not found", nil)
 			return
 		}
-		fmt.Printf("error: error fetching chirp: %s", err)
-		w.WriteHeader(500)
+		respondWithError(w, r, http.StatusInternalServerError, errCodeInternal, "Couldn't retrieve user", err)
 		return
 	}
-	ret := createdChirpLower{
-		ID:         chirp.ID,
-		Created_at: chirp.CreatedAt,
-		Updated_at: chirp.UpdatedAt,
-		Body:       chirp.Body,
-		User_id:    chirp.UserID,
+	if user.VerifiedAt.Valid {
+		respondWithError(w, r, http.StatusConflict, errCodeConflict, "Email is already verified", nil)
+		return
 	}
 
-	responseData, err := json.Marshal(ret)
+	err = c.sendVerificationEmail(r.Context(), user)
 	if err != nil {
-		fmt.Printf("error: error marshalling chirps: %s", err)
-		w.WriteHeader(500)
+		respondWithError(w, r, http.StatusInternalServerError, errCodeInternal, "Couldn't send verification email", err)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	w.Write(responseData)
+	w.WriteHeader(http.StatusAccepted)
 }
 
-func (c *apiConfig) addChirpsHandler(w http.ResponseWriter, r *http.Request) {
-	type inputPayload struct {
-		Body    string    `json:"body"`
-		User_id uuid.UUID `json:"user_id"`
-	}
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
 
-	type createdChirpLower struct {
-		ID         uuid.UUID `json:"id"`
-		Created_at time.Time `json:"created_at"`
-		Updated_at time.Time `json:"updated_at"`
-		Body       string    `json:"body"`
-		User_id    uuid.UUID `json:"user_id"`
+func isForeignKeyViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23503"
+}
+
+func (c *apiConfig) updateUserHandler(w http.ResponseWriter, r *http.Request) {
+	type paramsSent struct {
+		Email           string `json:"email"`
+		Password        string `json:"password"`
+		CurrentPassword string `json:"current_password"`
 	}
 
-	type queryParams struct {
-		body    string
-		user_id uuid.UUID
+	userID, ok := userIDFromContext(r.Context())
+	if !ok {
+		respondWithError(w, r, http.StatusUnauthorized, errCodeUnauthorized, "Not authenticated", nil)
+		return
 	}
 
-	decoder := json.NewDecoder(r.Body)
-	payload := inputPayload{}
-	err := decoder.Decode(&payload)
+	paramsDecoded := paramsSent{}
+	err := decodeJSON(r, &paramsDecoded)
 	if err != nil {
-		fmt.Printf("error: error decoding json: %s", err)
-		w.WriteHeader(500)
+		respondWithError(w, r, http.StatusBadRequest, errCodeInvalidJSON, "Invalid JSON body", err)
 		return
 	}
 
-	if len(payload.Body) > 140 {
-		fmt.Println("error: chirp body length exceeds 140 characters")
-		w.WriteHeader(400)
+	if paramsDecoded.Email == "" && paramsDecoded.Password == "" {
+		respondWithError(w, r, http.StatusBadRequest, errCodeBadRequest, "Provide an email or password to update", nil)
 		return
 	}
 
-	if len(payload.Body) == 0 {
-		fmt.Println("error: chirp body cannot be empty")
-		w.WriteHeader(400)
+	user, err := c.database.GetUserByID(r.Context(), userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithError(w, r, http.StatusNotFound, errCodeNotFound, "User not found", nil)
+			return
+		}
+		respondWithError(w, r, http.StatusInternalServerError, errCodeInternal, "Couldn't retrieve user", err)
 		return
 	}
 
-	params := database.CreateChirpParams{
-		Body:   payload.Body,
-		UserID: payload.User_id,
+	err = auth.CheckPassword(user.HashedPassword, paramsDecoded.CurrentPassword)
+	if err != nil {
+		respondWithError(w, r, http.StatusUnauthorized, errCodeUnauthorized, "Current password is missing or incorrect", nil)
+		return
 	}
 
-	createdChirp, err := c.database.CreateChirp(r.Context(), params)
+	params := database.UpdateUserParams{
+		ID:             user.ID,
+		Email:          user.Email,
+		HashedPassword: user.HashedPassword,
+	}
+	if paramsDecoded.Email != "" {
+		params.Email, err = email.Normalize(paramsDecoded.Email)
+		if err != nil {
+			respondWithError(w, r, http.StatusUnprocessableEntity, errCodeUnprocessable, "Invalid email address", err)
+			return
+		}
+	}
+	emailChanged := params.Email != user.Email
+	passwordChanged := paramsDecoded.Password != ""
+	if passwordChanged {
+		hashed, err := auth.HashPassword(paramsDecoded.Password)
+		if err != nil {
+			respondWithError(w, r, http.StatusInternalServerError, errCodeInternal, "Couldn't hash password", err)
+			return
+		}
+		params.HashedPassword = hashed
+	}
+
+	updatedUsr, err := c.database.UpdateUser(r.Context(), params)
 	if err != nil {
-		fmt.Printf("error: error creating new chirp: %s", err)
-		w.WriteHeader(500)
+		if isUniqueViolation(err) {
+			respondWithError(w, r, http.StatusConflict, errCodeConflict, "Email is already in use", err)
+			return
+		}
+		respondWithError(w, r, http.StatusInternalServerError, errCodeInternal, "Couldn't update user", err)
 		return
 	}
-	newChirpDataLower := createdChirpLower{
-		ID:         createdChirp.ID,
-		Created_at: createdChirp.CreatedAt,
-		Updated_at: createdChirp.UpdatedAt,
-		Body:       createdChirp.Body,
-		User_id:    createdChirp.UserID,
+
+	if passwordChanged {
+		c.revokeTokenFamily(r.Context(), updatedUsr.ID)
+	}
+	if emailChanged {
+		err = c.sendVerificationEmail(r.Context(), updatedUsr)
+		if err != nil {
+			requestLogger(r.Context()).Error("error sending verification email", "user_id", updatedUsr.ID, "error", err)
+		}
+	}
+
+	respondWithJSON(w, http.StatusOK, newUserResponse(updatedUsr))
+}
+
+// forgotPasswordHandler emails a password reset token when the address
+// belongs to an account. It always answers 202 straight away so it can't be
+// used to find out which addresses are registered.
+func (c *apiConfig) forgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	type paramsSent struct {
+		Email string `json:"email"`
 	}
-	responseData, err := json.Marshal(newChirpDataLower)
+
+	paramsDecoded := paramsSent{}
+	err := decodeJSON(r, &paramsDecoded)
 	if err != nil {
-		fmt.Printf("error: error decoding response: %s", err)
-		w.WriteHeader(500)
+		respondWithError(w, r, http.StatusBadRequest, errCodeInvalidJSON, "Invalid JSON body", err)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	w.Write(responseData)
+
+	// Look the account up and send the email after responding, so how long
+	// the request takes doesn't reveal whether the account exists
+	ctx := context.WithoutCancel(r.Context())
+	started := c.goBackground(func() {
+		ctx, cancel := context.WithTimeout(ctx, passwordResetEmailTimeout)
+		defer cancel()
+		err := c.requestPasswordReset(ctx, paramsDecoded.Email)
+		if err != nil {
+			requestLogger(ctx).Error("error sending password reset email", "error", err)
+		}
+	})
+	if !started {
+		requestLogger(ctx).Warn("shutting down: password reset email not sent")
+	}
+
+	w.WriteHeader(http.StatusAccepted)
 }
 
-func (c *apiConfig) loginHandler(w http.ResponseWriter, r *http.Request) {
-	type expected struct {
-		Password string `json:"password"`
-		Email    string `json:"email"`
+// goBackground runs fn on its own goroutine, tracked so shutdown can wait for
+// it. Once the server is draining it refuses new work and returns false.
+func (c *apiConfig) goBackground(fn func()) bool {
+	c.backgroundMu.Lock()
+	defer c.backgroundMu.Unlock()
+	if c.draining.Load() {
+		return false
 	}
+	c.background.Add(1)
+	go func() {
+		defer c.background.Done()
+		fn()
+	}()
+	return true
+}
 
-	decoder := json.NewDecoder(r.Body)
-	paramsDecoded := expected{}
-	err := decoder.Decode(&paramsDecoded)
+// passwordResetEmailTimeout bounds the background lookup and delivery of a
+// password reset email.
+const passwordResetEmailTimeout = 30 * time.Second
+
+// requestPasswordReset sends a reset token to address if an account uses it.
+// Unknown or malformed addresses are not an error.
+func (c *apiConfig) requestPasswordReset(ctx context.Context, address string) error {
+	address, err := email.Normalize(address)
 	if err != nil {
-		fmt.Printf("error: error decoding json: %s", err)
-		w.WriteHeader(500)
-		return
+		return nil
+	}
+	user, err := c.database.GetUserByEmail(ctx, address)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return fmt.Errorf("error retrieving user: %w", err)
 	}
 
-	hashed, err := c.database.GetHashedPasswordByUser(r.Context(), paramsDecoded.Email)
+	token, err := auth.MakeOneTimeToken()
 	if err != nil {
-		fmt.Printf("error: error getting password: %s", err)
-		w.WriteHeader(500)
-		return
+		return err
+	}
+	err = c.database.CreatePasswordResetToken(ctx, database.CreatePasswordResetTokenParams{
+		TokenHash: auth.HashToken(token),
+		UserID:    user.ID,
+		ExpiresAt: time.Now().UTC().Add(c.config.PasswordResetTTL),
+	})
+	if err != nil {
+		return fmt.Errorf("error storing password reset token: %w", err)
 	}
 
-	if hashed == "" {
-		w.WriteHeader(404)
-		return
+	return c.mailer.Send(ctx, email.Message{
+		To:      user.Email,
+		Subject: "Reset your Chirpy password",
+		Body: fmt.Sprintf("Someone asked to reset the password for this Chirpy account. To choose a new one, send this token with your new password to POST /api/password/reset:\n\n    %s\n\nIt expires in %s and works once. If you did not ask for this, ignore this email; your password has not changed.\n",
+			token, c.config.PasswordResetTTL),
+	})
+}
+
+// resetPasswordHandler sets a new password using an emailed reset token. The
+// token is spent only if the password changes, and every refresh token and
+// outstanding reset token for the user is revoked with it.
+func (c *apiConfig) resetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	type paramsSent struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
 	}
 
-	err = auth.CheckPassword(hashed, paramsDecoded.Password)
+	paramsDecoded := paramsSent{}
+	err := decodeJSON(r, &paramsDecoded)
 	if err != nil {
-		// Password is incorrect
-		w.WriteHeader(401)
+		respondWithError(w, r, http.StatusBadRequest, errCodeInvalidJSON, "Invalid JSON body", err)
+		return
+	}
+	if paramsDecoded.Token == "" {
+		respondWithError(w, r, http.StatusBadRequest, errCodeBadRequest, "Missing reset token", nil)
+		return
+	}
+	if paramsDecoded.Password == "" {
+		respondWithError(w, r, http.StatusBadRequest, errCodeBadRequest, "Missing new password", nil)
 		return
 	}
 
-	user, err := c.database.GetUserByEmail(r.Context(), paramsDecoded.Email)
+	hashed, err := auth.HashPassword(paramsDecoded.Password)
 	if err != nil {
-		fmt.Printf("error: error retrieving user: %s", err)
-		w.WriteHeader(500)
+		respondWithError(w, r, http.StatusUnprocessableEntity, errCodeUnprocessable, "Couldn't use that password", err)
 		return
 	}
 
-	type userResponse struct {
-		ID         uuid.UUID `json:"id"`
-		Created_at time.Time `json:"created_at"`
-		Updated_at time.Time `json:"updated_at"`
-		Email      string    `json:"email"`
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		respondWithError(w, r, http.StatusInternalServerError, errCodeInternal, "Couldn't reset password", err)
+		return
 	}
+	defer tx.Rollback()
+	queries := c.database.WithTx(tx)
 
-	response := userResponse{
-		ID:         user.ID,
-		Created_at: user.CreatedAt,
-		Updated_at: user.UpdatedAt,
-		Email:      user.Email,
+	userID, err := queries.ConsumePasswordResetToken(ctx, auth.HashToken(paramsDecoded.Token))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithError(w, r, http.StatusBadRequest, errCodeBadRequest, "Invalid or expired reset token", nil)
+			return
+		}
+		respondWithError(w, r, http.StatusInternalServerError, errCodeInternal, "Couldn't check reset token", err)
+		return
 	}
-
-	responseData, err := json.Marshal(response)
+	user, err := queries.UpdateUserPassword(ctx, database.UpdateUserPasswordParams{
+		ID:             userID,
+		HashedPassword: hashed,
+	})
 	if err != nil {
-		fmt.Printf("error: error marshalling response: %s", err)
-		w.WriteHeader(500)
+		respondWithError(w, r, http.StatusInternalServerError, errCodeInternal, "Couldn't reset password", err)
 		return
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	w.Write(responseData)
-}
-
-func main() {
-	mux := http.NewServeMux()
-	err := godotenv.Load(".env")
+	err = queries.InvalidatePasswordResetTokensForUser(ctx, userID)
 	if err != nil {
-		fmt.Println("error: error loading .env file")
+		respondWithError(w, r, http.StatusInternalServerError, errCodeInternal, "Couldn't reset password", err)
 		return
 	}
-
-	cfg := &apiConfig{}
-
-	fileServer := http.FileServer(http.Dir("."))
-
-	dbURL := os.Getenv("DB_URL")
-	db, err := sql.Open("postgres", dbURL)
+	// A reset usually means the old password leaked, so sessions opened with
+	// it must not outlive the change
+	err = queries.RevokeAllRefreshTokensForUser(ctx, userID)
+	if err != nil {
+		respondWithError(w, r, http.StatusInternalServerError, errCodeInternal, "Couldn't reset password", err)
+		return
+	}
+	err = tx.Commit()
 	if err != nil {
-		fmt.Println("error: server error")
+		respondWithError(w, r, http.StatusInternalServerError, errCodeInternal, "Couldn't reset password", err)
 		return
 	}
 
-	dbQueries := database.New(db)
-	cfg.database = dbQueries
+	// Proving control of the inbox is at least as good as an admin unlock
+	err = c.database.ClearLoginFailures(ctx, accountThrottleKey(user.Email))
+	if err != nil {
+		requestLogger(ctx).Error("error clearing login failures", "user_id", user.ID, "error", err)
+	}
 
-	mux.Handle("/app/", cfg.middlewareMetricsInc(http.StripPrefix("/app", fileServer)))
-	mux.HandleFunc("GET /api/healthz", healthCheckHandler)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+const (
+	defaultChirpPageSize = 50
+	maxChirpPageSize     = 100
+)
+
+// chirpResponse is the public representation of a chirp shared by every
+// chirp route.
+type chirpResponse struct {
+	ID            uuid.UUID  `json:"id"`
+	Created_at    time.Time  `json:"created_at"`
+	Updated_at    time.Time  `json:"updated_at"`
+	Body          string     `json:"body"`
+	User_id       uuid.UUID  `json:"user_id"`
+	Edited        bool       `json:"edited"`
+	RevisionCount int32      `json:"revision_count"`
+	InReplyTo     *uuid.UUID `json:"in_reply_to"`
+	ReplyCount    int32      `json:"reply_count"`
+	LikeCount     int32      `json:"like_count"`
+	LikedByMe     bool       `json:"liked_by_me"`
+}
+
+func newChirpResponse(chirp database.Chirp) chirpResponse {
+	resp := chirpResponse{
+		ID:            chirp.ID,
+		Created_at:    chirp.CreatedAt,
+		Updated_at:    chirp.UpdatedAt,
+		Body:          chirp.Body,
+		User_id:       chirp.UserID,
+		Edited:        chirp.RevisionCount > 0,
+		RevisionCount: chirp.RevisionCount,
+		ReplyCount:    chirp.ReplyCount,
+		LikeCount:     chirp.LikeCount,
+	}
+	if chirp.InReplyTo.Valid {
+		resp.InReplyTo = &chirp.InReplyTo.UUID
+	}
+	return resp
+}
+
+// parseChirpPageSize reads the optional limit query parameter of paginated
+// chirp routes. Limits above maxChirpPageSize are clamped to it.
+func parseChirpPageSize(value string) (int32, error) {
+	if value == "" {
+		return defaultChirpPageSize, nil
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 1 {
+		return 0, errors.New("limit must be a positive integer")
+	}
+	return int32(min(limit, maxChirpPageSize)), nil
+}
+
+func encodeChirpCursor(chirp database.Chirp) string {
+	return encodeCursor(chirp.CreatedAt, chirp.ID)
+}
+
+// encodeCursor builds an opaque keyset pagination cursor from a timestamp and
+// a tie-breaking ID. decodeChirpCursor reads it back.
+func encodeCursor(t time.Time, id uuid.UUID) string {
+	raw := t.Format(time.RFC3339Nano) + "|" + id.String()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeChirpCursor(cursor string) (time.Time, uuid.UUID, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, uuid.Nil, fmt.Errorf("invalid cursor: %s", err)
+	}
+	createdAtStr, idStr, found := strings.Cut(string(raw), "|")
+	if !found {
+		return time.Time{}, uuid.Nil, errors.New("invalid cursor")
+	}
+	createdAt, err := time.Parse(time.RFC3339Nano, createdAtStr)
+	if err != nil {
+		return time.Time{}, uuid.Nil, fmt.Errorf("invalid cursor time: %s", err)
+	}
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		return time.Time{}, uuid.Nil, fmt.Errorf("invalid cursor id: %s", err)
+	}
+	return createdAt, id, nil
+}
+
+func (c *apiConfig) getAllChirpsHandler(w http.ResponseWriter, r *http.Request) {
+	type chirpPage struct {
+		Chirps     []chirpResponse `json:"chirps"`
+		NextCursor string          `json:"next_cursor,omitempty"`
+	}
+
+	query := r.URL.Query()
+	params := database.ListChirpsAscParams{}
+
+	if authorStr := query.Get("author_id"); authorStr != "" {
+		authorID, err := uuid.Parse(authorStr)
+		if err != nil {
+			respondWithError(w, r, http.StatusBadRequest, errCodeInvalidID, "Invalid author_id", err)
+			return
+		}
+		params.AuthorID = uuid.NullUUID{UUID: authorID, Valid: true}
+	}
+
+	for _, bound := range []struct {
+		key  string
+		dest *sql.NullTime
+	}{
+		{"since", &params.Since},
+		{"until", &params.Until},
+	} {
+		value := query.Get(bound.key)
+		if value == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			respondWithError(w, r, http.StatusBadRequest, errCodeBadRequest, "Invalid "+bound.key+", expected an RFC 3339 timestamp", err)
+			return
+		}
+		*bound.dest = sql.NullTime{Time: parsed.UTC(), Valid: true}
+	}
+
+	pageSize, err := parseChirpPageSize(query.Get("limit"))
+	if err != nil {
+		respondWithError(w, r, http.StatusBadRequest, errCodeBadRequest, err.Error(), nil)
+		return
+	}
+	params.RowLimit = pageSize
+
+	if cursor := query.Get("cursor"); cursor != "" {
+		createdAt, id, err := decodeChirpCursor(cursor)
+		if err != nil {
+			respondWithError(w, r, http.StatusBadRequest, errCodeBadRequest, "Invalid cursor", err)
+			return
+		}
+		params.CursorCreatedAt = sql.NullTime{Time: createdAt, Valid: true}
+		params.CursorID = uuid.NullUUID{UUID: id, Valid: true}
+	}
+
+	// Fetch one extra row to learn whether another page follows
+	params.RowLimit++
+
+	var resp []database.Chirp
+	switch query.Get("sort") {
+	case "", "asc":
+		resp, err = c.database.ListChirpsAsc(r.Context(), params)
+	case "desc":
+		resp, err = c.database.ListChirpsDesc(r.Context(), database.ListChirpsDescParams(params))
+	default:
+		respondWithError(w, r, http.StatusBadRequest, errCodeBadRequest, "sort must be asc or desc", nil)
+		return
+	}
+	if err != nil {
+		respondWithError(w, r, http.StatusInternalServerError, errCodeInternal, "Couldn't list chirps", err)
+		return
+	}
+
+	page := chirpPage{
+		Chirps: []chirpResponse{},
+	}
+	if len(resp) > int(pageSize) {
+		resp = resp[:pageSize]
+		page.NextCursor = encodeChirpCursor(resp[len(resp)-1])
+	}
+
+	ids := make([]uuid.UUID, 0, len(resp))
+	for _, chirp := range resp {
+		ids = append(ids, chirp.ID)
+	}
+	liked, err := c.likedByCaller(r.Context(), ids)
+	if err != nil {
+		respondWithError(w, r, http.StatusInternalServerError, errCodeInternal, "Couldn't check likes", err)
+		return
+	}
+	for _, chirp := range resp {
+		chirpResp := newChirpResponse(chirp)
+		chirpResp.LikedByMe = liked[chirp.ID]
+		page.Chirps = append(page.Chirps, chirpResp)
+	}
+	respondWithJSON(w, http.StatusOK, page)
+}
+
+func (c *apiConfig) getSingleChirpHandler(w http.ResponseWriter, r *http.Request) {
+	queryIDstr := r.PathValue("chirpID")
+
+	queryID, err := uuid.Parse(queryIDstr)
+	if err != nil {
+		respondWithError(w, r, http.StatusBadRequest, errCodeInvalidID, "Invalid chirp ID", err)
+		return
+	}
+
+	chirp, err := c.database.GetSingleChirp(r.Context(), queryID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithError(w, r, http.StatusNotFound, errCodeNotFound, "Chirp not found", nil)
+			return
+		}
+		respondWithError(w, r, http.StatusInternalServerError, errCodeInternal, "Couldn't fetch chirp", err)
+		return
+	}
+
+	liked, err := c.likedByCaller(r.Context(), []uuid.UUID{chirp.ID})
+	if err != nil {
+		respondWithError(w, r, http.StatusInternalServerError, errCodeInternal, "Couldn't check likes", err)
+		return
+	}
+	resp := newChirpResponse(chirp)
+	resp.LikedByMe = liked[chirp.ID]
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// likedByCaller reports which of chirpIDs the authenticated caller has liked.
+// Anonymous callers have liked nothing.
+func (c *apiConfig) likedByCaller(ctx context.Context, chirpIDs []uuid.UUID) (map[uuid.UUID]bool, error) {
+	userID, ok := userIDFromContext(ctx)
+	if !ok || len(chirpIDs) == 0 {
+		return nil, nil
+	}
+	likedIDs, err := c.database.ListLikedChirpIDs(ctx, database.ListLikedChirpIDsParams{
+		UserID:   userID,
+		ChirpIds: chirpIDs,
+	})
+	if err != nil {
+		return nil, err
+	}
+	liked := make(map[uuid.UUID]bool, len(likedIDs))
+	for _, id := range likedIDs {
+		liked[id] = true
+	}
+	return liked, nil
+}
+
+// likeChirpHandler likes a chirp as the caller. Liking it again is a no-op.
+func (c *apiConfig) likeChirpHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDFromContext(r.Context())
+	if !ok {
+		respondWithError(w, r, http.StatusUnauthorized, errCodeUnauthorized, "Not authenticated", nil)
+		return
+	}
+
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, r, http.StatusBadRequest, errCodeInvalidID, "Invalid chirp ID", err)
+		return
+	}
+
+	_, err = c.database.LikeChirp(r.Context(), database.LikeChirpParams{
+		UserID:  userID,
+		ChirpID: chirpID,
+	})
+	if err != nil {
+		if isForeignKeyViolation(err) {
+			respondWithError(w, r, http.StatusNotFound, errCodeNotFound, "Chirp not found", nil)
+			return
+		}
+		respondWithError(w, r, http.StatusInternalServerError, errCodeInternal, "Couldn't like chirp", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// unlikeChirpHandler removes the caller's like. Removing a like that isn't
+// there is a no-op.
+func (c *apiConfig) unlikeChirpHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDFromContext(r.Context())
+	if !ok {
+		respondWithError(w, r, http.StatusUnauthorized, errCodeUnauthorized, "Not authenticated", nil)
+		return
+	}
+
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, r, http.StatusBadRequest, errCodeInvalidID, "Invalid chirp ID", err)
+		return
+	}
+
+	_, err = c.database.UnlikeChirp(r.Context(), database.UnlikeChirpParams{
+		UserID:  userID,
+		ChirpID: chirpID,
+	})
+	if err != nil {
+		respondWithError(w, r, http.StatusInternalServerError, errCodeInternal, "Couldn't unlike chirp", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getUserLikesHandler lists the chirps a user has liked, most recently liked
+// first.
+func (c *apiConfig) getUserLikesHandler(w http.ResponseWriter, r *http.Request) {
+	type likedChirp struct {
+		chirpResponse
+		LikedAt time.Time `json:"liked_at"`
+	}
+	type likesPage struct {
+		Chirps     []likedChirp `json:"chirps"`
+		NextCursor string       `json:"next_cursor,omitempty"`
+	}
+
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, r, http.StatusBadRequest, errCodeInvalidID, "Invalid user ID", err)
+		return
+	}
+
+	query := r.URL.Query()
+	pageSize, err := parseChirpPageSize(query.Get("limit"))
+	if err != nil {
+		respondWithError(w, r, http.StatusBadRequest, errCodeBadRequest, err.Error(), nil)
+		return
+	}
+	params := database.ListUserLikesParams{
+		UserID: userID,
+		// Fetch one extra row to learn whether another page follows
+		RowLimit: pageSize + 1,
+	}
+	if cursor := query.Get("cursor"); cursor != "" {
+		likedAt, chirpID, err := decodeChirpCursor(cursor)
+		if err != nil {
+			respondWithError(w, r, http.StatusBadRequest, errCodeBadRequest, "Invalid cursor", err)
+			return
+		}
+		params.CursorLikedAt = sql.NullTime{Time: likedAt, Valid: true}
+		params.CursorChirpID = uuid.NullUUID{UUID: chirpID, Valid: true}
+	}
+
+	_, err = c.database.GetUserByID(r.Context(), userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithError(w, r, http.StatusNotFound, errCodeNotFound, "User not found", nil)
+			return
+		}
+		respondWithError(w, r, http.StatusInternalServerError, errCodeInternal, "Couldn't retrieve user", err)
+		return
+	}
+
+	likes, err := c.database.ListUserLikes(r.Context(), params)
+	if err != nil {
+		respondWithError(w, r, http.StatusInternalServerError, errCodeInternal, "Couldn't list likes", err)
+		return
+	}
+
+	page := likesPage{
+		Chirps: []likedChirp{},
+	}
+	if len(likes) > int(pageSize) {
+		likes = likes[:pageSize]
+		last := likes[len(likes)-1]
+		page.NextCursor = encodeCursor(last.LikedAt, last.ID)
+	}
+
+	ids := make([]uuid.UUID, 0, len(likes))
+	for _, like := range likes {
+		ids = append(ids, like.ID)
+	}
+	liked, err := c.likedByCaller(r.Context(), ids)
+	if err != nil {
+		respondWithError(w, r, http.StatusInternalServerError, errCodeInternal, "Couldn't check likes", err)
+		return
+	}
+	for _, like := range likes {
+		chirpResp := newChirpResponse(database.Chirp{
+			ID:            like.ID,
+			CreatedAt:     like.CreatedAt,
+			UpdatedAt:     like.UpdatedAt,
+			Body:          like.Body,
+			UserID:        like.UserID,
+			RevisionCount: like.RevisionCount,
+			InReplyTo:     like.InReplyTo,
+			ReplyCount:    like.ReplyCount,
+			LikeCount:     like.LikeCount,
+		})
+		chirpResp.LikedByMe = liked[like.ID]
+		page.Chirps = append(page.Chirps, likedChirp{chirpResponse: chirpResp, LikedAt: like.LikedAt})
+	}
+	respondWithJSON(w, http.StatusOK, page)
+}
+
+func (c *apiConfig) chirpRules() chirptext.Rules {
+	return chirptext.Rules{
+		MaxLength: c.config.MaxChirpLength,
+		URLLength: c.config.ChirpURLLength,
+	}
+}
+
+// limitsHandler publishes the chirp length rules so clients can count
+// characters the same way the server does.
+func (c *apiConfig) limitsHandler(w http.ResponseWriter, r *http.Request) {
+	type chirpLimits struct {
+		MaxLength     int    `json:"max_length"`
+		URLLength     int    `json:"url_length"`
+		LengthUnit    string `json:"length_unit"`
+		Normalization string `json:"normalization"`
+	}
+	type limitsResponse struct {
+		Chirp chirpLimits `json:"chirp"`
+	}
+
+	rules := c.chirpRules()
+	respondWithJSON(w, http.StatusOK, limitsResponse{
+		Chirp: chirpLimits{
+			MaxLength:     rules.MaxLength,
+			URLLength:     rules.URLLength,
+			LengthUnit:    "grapheme",
+			Normalization: "NFC",
+		},
+	})
+}
+
+func (c *apiConfig) addChirpsHandler(w http.ResponseWriter, r *http.Request) {
+	type inputPayload struct {
+		Body      string        `json:"body"`
+		InReplyTo uuid.NullUUID `json:"in_reply_to"`
+	}
+
+	userID, ok := userIDFromContext(r.Context())
+	if !ok {
+		respondWithError(w, r, http.StatusUnauthorized, errCodeUnauthorized, "Not authenticated", nil)
+		return
+	}
+
+	payload := inputPayload{}
+	err := decodeJSON(r, &payload)
+	if err != nil {
+		respondWithError(w, r, http.StatusBadRequest, errCodeInvalidJSON, "Invalid JSON body", err)
+		return
+	}
+
+	cleanedBody, ok := c.prepareChirpBody(w, r, payload.Body)
+	if !ok {
+		return
+	}
+
+	params := database.CreateChirpParams{
+		Body:      cleanedBody,
+		UserID:    userID,
+		InReplyTo: payload.InReplyTo,
+	}
+
+	createdChirp, err := c.database.CreateChirp(r.Context(), params)
+	if err != nil {
+		if isForeignKeyViolation(err) {
+			respondWithError(w, r, http.StatusUnprocessableEntity, errCodeUnprocessable, "The chirp being replied to does not exist", err)
+			return
+		}
+		respondWithError(w, r, http.StatusInternalServerError, errCodeInternal, "Couldn't create chirp", err)
+		return
+	}
+	c.metrics.chirpsCreated.Inc()
+	respondWithJSON(w, http.StatusCreated, newChirpResponse(createdChirp))
+}
+
+// prepareChirpBody normalizes a submitted chirp body and runs it through the
+// length rules and content filter. When the body is unacceptable it writes the
+// error response and returns false.
+func (c *apiConfig) prepareChirpBody(w http.ResponseWriter, r *http.Request, body string) (string, bool) {
+	body = chirptext.Normalize(body)
+	err := c.chirpRules().Check(body)
+	if err != nil {
+		if errors.Is(err, chirptext.ErrEmpty) {
+			respondWithError(w, r, http.StatusUnprocessableEntity, errCodeUnprocessable, "Chirp body cannot be empty", nil)
+			return "", false
+		}
+		respondWithError(w, r, http.StatusUnprocessableEntity, errCodeUnprocessable, "Chirp is too long", nil)
+		return "", false
+	}
+
+	cleanedBody, err := c.contentFilter.Apply(body)
+	if err != nil {
+		var rejected *moderation.RejectedError
+		if errors.As(err, &rejected) {
+			respondWithError(w, r, http.StatusUnprocessableEntity, errCodeUnprocessable, "Chirp contains banned words", err)
+			return "", false
+		}
+		respondWithError(w, r, http.StatusInternalServerError, errCodeInternal, "Couldn't filter chirp", err)
+		return "", false
+	}
+	return cleanedBody, true
+}
+
+// editChirpHandler lets the author replace a chirp's body within the edit
+// window. The previous body is kept in chirp_revisions.
+func (c *apiConfig) editChirpHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	type inputPayload struct {
+		Body string `json:"body"`
+	}
+
+	userID, ok := userIDFromContext(ctx)
+	if !ok {
+		respondWithError(w, r, http.StatusUnauthorized, errCodeUnauthorized, "Not authenticated", nil)
+		return
+	}
+
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, r, http.StatusBadRequest, errCodeInvalidID, "Invalid chirp ID", err)
+		return
+	}
+
+	payload := inputPayload{}
+	err = decodeJSON(r, &payload)
+	if err != nil {
+		respondWithError(w, r, http.StatusBadRequest, errCodeInvalidJSON, "Invalid JSON body", err)
+		return
+	}
+
+	chirp, err := c.database.GetSingleChirp(ctx, chirpID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithError(w, r, http.StatusNotFound, errCodeNotFound, "Chirp not found", nil)
+			return
+		}
+		respondWithError(w, r, http.StatusInternalServerError, errCodeInternal, "Couldn't fetch chirp", err)
+		return
+	}
+	if chirp.UserID != userID {
+		respondWithError(w, r, http.StatusForbidden, errCodeForbidden, "You can only edit your own chirps", nil)
+		return
+	}
+	if time.Since(chirp.CreatedAt) > c.config.ChirpEditWindow {
+		respondWithError(w, r, http.StatusForbidden, errCodeForbidden, "This chirp can no longer be edited", nil)
+		return
+	}
+
+	body, ok := c.prepareChirpBody(w, r, payload.Body)
+	if !ok {
+		return
+	}
+	if body == chirp.Body {
+		respondWithJSON(w, http.StatusOK, newChirpResponse(chirp))
+		return
+	}
+
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		respondWithError(w, r, http.StatusInternalServerError, errCodeInternal, "Couldn't edit chirp", err)
+		return
+	}
+	defer tx.Rollback()
+	queries := c.database.WithTx(tx)
+
+	// Only applies if nobody edited or deleted the chirp since we read it
+	updated, err := queries.UpdateChirpBody(ctx, database.UpdateChirpBodyParams{
+		ID:                    chirp.ID,
+		Body:                  body,
+		ExpectedRevisionCount: chirp.RevisionCount,
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithError(w, r, http.StatusConflict, errCodeConflict, "Chirp was changed by another request, fetch it and try again", nil)
+			return
+		}
+		respondWithError(w, r, http.StatusInternalServerError, errCodeInternal, "Couldn't edit chirp", err)
+		return
+	}
+	err = queries.CreateChirpRevision(ctx, database.CreateChirpRevisionParams{
+		ChirpID:   chirp.ID,
+		Revision:  updated.RevisionCount,
+		Body:      chirp.Body,
+		CreatedAt: chirp.UpdatedAt,
+	})
+	if err != nil {
+		respondWithError(w, r, http.StatusInternalServerError, errCodeInternal, "Couldn't save chirp revision", err)
+		return
+	}
+	err = tx.Commit()
+	if err != nil {
+		respondWithError(w, r, http.StatusInternalServerError, errCodeInternal, "Couldn't edit chirp", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, newChirpResponse(updated))
+}
+
+// getChirpThreadHandler returns a chirp with the chain of chirps it replies
+// to, root first, and a page of the replies below it in depth-first order.
+func (c *apiConfig) getChirpThreadHandler(w http.ResponseWriter, r *http.Request) {
+	type threadReply struct {
+		chirpResponse
+		Depth int32 `json:"depth"`
+	}
+	type threadResponse struct {
+		Ancestors  []chirpResponse `json:"ancestors"`
+		Chirp      chirpResponse   `json:"chirp"`
+		Replies    []threadReply   `json:"replies"`
+		NextCursor string          `json:"next_cursor,omitempty"`
+	}
+
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, r, http.StatusBadRequest, errCodeInvalidID, "Invalid chirp ID", err)
+		return
+	}
+
+	query := r.URL.Query()
+	pageSize, err := parseChirpPageSize(query.Get("limit"))
+	if err != nil {
+		respondWithError(w, r, http.StatusBadRequest, errCodeBadRequest, err.Error(), nil)
+		return
+	}
+	params := database.ListChirpDescendantsParams{
+		RootID: chirpID,
+		// Fetch one extra row to learn whether another page follows
+		RowLimit: pageSize + 1,
+	}
+	if cursor := query.Get("cursor"); cursor != "" {
+		path, err := base64.RawURLEncoding.DecodeString(cursor)
+		if err != nil || len(path) == 0 {
+			respondWithError(w, r, http.StatusBadRequest, errCodeBadRequest, "Invalid cursor", err)
+			return
+		}
+		params.AfterPath = sql.NullString{String: string(path), Valid: true}
+	}
+
+	chirp, err := c.database.GetSingleChirp(r.Context(), chirpID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithError(w, r, http.StatusNotFound, errCodeNotFound, "Chirp not found", nil)
+			return
+		}
+		respondWithError(w, r, http.StatusInternalServerError, errCodeInternal, "Couldn't fetch chirp", err)
+		return
+	}
+
+	ancestors, err := c.database.ListChirpAncestors(r.Context(), chirp.ID)
+	if err != nil {
+		respondWithError(w, r, http.StatusInternalServerError, errCodeInternal, "Couldn't fetch thread", err)
+		return
+	}
+	replies, err := c.database.ListChirpDescendants(r.Context(), params)
+	if err != nil {
+		respondWithError(w, r, http.StatusInternalServerError, errCodeInternal, "Couldn't fetch thread", err)
+		return
+	}
+
+	var nextCursor string
+	if len(replies) > int(pageSize) {
+		replies = replies[:pageSize]
+		nextCursor = base64.RawURLEncoding.EncodeToString([]byte(replies[len(replies)-1].Path))
+	}
+
+	ids := make([]uuid.UUID, 0, len(ancestors)+1+len(replies))
+	for _, ancestor := range ancestors {
+		ids = append(ids, ancestor.ID)
+	}
+	ids = append(ids, chirp.ID)
+	for _, reply := range replies {
+		ids = append(ids, reply.ID)
+	}
+	liked, err := c.likedByCaller(r.Context(), ids)
+	if err != nil {
+		respondWithError(w, r, http.StatusInternalServerError, errCodeInternal, "Couldn't check likes", err)
+		return
+	}
+
+	resp := threadResponse{
+		Ancestors:  []chirpResponse{},
+		Chirp:      newChirpResponse(chirp),
+		Replies:    []threadReply{},
+		NextCursor: nextCursor,
+	}
+	resp.Chirp.LikedByMe = liked[chirp.ID]
+	for _, ancestor := range ancestors {
+		ancestorResp := newChirpResponse(ancestor)
+		ancestorResp.LikedByMe = liked[ancestor.ID]
+		resp.Ancestors = append(resp.Ancestors, ancestorResp)
+	}
+	for _, reply := range replies {
+		replyResp := newChirpResponse(database.Chirp{
+			ID:            reply.ID,
+			CreatedAt:     reply.CreatedAt,
+			UpdatedAt:     reply.UpdatedAt,
+			Body:          reply.Body,
+			UserID:        reply.UserID,
+			RevisionCount: reply.RevisionCount,
+			InReplyTo:     reply.InReplyTo,
+			ReplyCount:    reply.ReplyCount,
+			LikeCount:     reply.LikeCount,
+		})
+		replyResp.LikedByMe = liked[reply.ID]
+		resp.Replies = append(resp.Replies, threadReply{
+			chirpResponse: replyResp,
+			Depth:         reply.Depth,
+		})
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// getChirpRevisionsHandler returns a chirp with every body it had before,
+// oldest first.
+func (c *apiConfig) getChirpRevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	type revisionResponse struct {
+		Revision   int32     `json:"revision"`
+		Body       string    `json:"body"`
+		Created_at time.Time `json:"created_at"`
+	}
+	type revisionsResponse struct {
+		Chirp     chirpResponse      `json:"chirp"`
+		Revisions []revisionResponse `json:"revisions"`
+	}
+
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, r, http.StatusBadRequest, errCodeInvalidID, "Invalid chirp ID", err)
+		return
+	}
+
+	chirp, err := c.database.GetSingleChirp(r.Context(), chirpID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithError(w, r, http.StatusNotFound, errCodeNotFound, "Chirp not found", nil)
+			return
+		}
+		respondWithError(w, r, http.StatusInternalServerError, errCodeInternal, "Couldn't fetch chirp", err)
+		return
+	}
+
+	revisions, err := c.database.ListChirpRevisions(r.Context(), chirp.ID)
+	if err != nil {
+		respondWithError(w, r, http.StatusInternalServerError, errCodeInternal, "Couldn't list chirp revisions", err)
+		return
+	}
+
+	liked, err := c.likedByCaller(r.Context(), []uuid.UUID{chirp.ID})
+	if err != nil {
+		respondWithError(w, r, http.StatusInternalServerError, errCodeInternal, "Couldn't check likes", err)
+		return
+	}
+
+	resp := revisionsResponse{
+		Chirp:     newChirpResponse(chirp),
+		Revisions: []revisionResponse{},
+	}
+	resp.Chirp.LikedByMe = liked[chirp.ID]
+	for _, revision := range revisions {
+		resp.Revisions = append(resp.Revisions, revisionResponse{
+			Revision:   revision.Revision,
+			Body:       revision.Body,
+			Created_at: revision.CreatedAt,
+		})
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+func (c *apiConfig) deleteChirpHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDFromContext(r.Context())
+	if !ok {
+		respondWithError(w, r, http.StatusUnauthorized, errCodeUnauthorized, "Not authenticated", nil)
+		return
+	}
+	c.deleteChirp(w, r, userID)
+}
+
+func (c *apiConfig) adminDeleteChirpHandler(w http.ResponseWriter, r *http.Request) {
+	c.deleteChirp(w, r, uuid.Nil)
+}
+
+// deleteChirp removes the chirp named in the path. A non-nil ownerID restricts
+// the delete to chirps authored by that user; uuid.Nil skips the check.
+func (c *apiConfig) deleteChirp(w http.ResponseWriter, r *http.Request, ownerID uuid.UUID) {
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, r, http.StatusBadRequest, errCodeInvalidID, "Invalid chirp ID", err)
+		return
+	}
+
+	chirp, err := c.database.GetSingleChirp(r.Context(), chirpID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithError(w, r, http.StatusNotFound, errCodeNotFound, "Chirp not found", nil)
+			return
+		}
+		respondWithError(w, r, http.StatusInternalServerError, errCodeInternal, "Couldn't fetch chirp", err)
+		return
+	}
+
+	if ownerID != uuid.Nil && chirp.UserID != ownerID {
+		respondWithError(w, r, http.StatusForbidden, errCodeForbidden, "You can only delete your own chirps", nil)
+		return
+	}
+
+	err = c.database.DeleteChirp(r.Context(), chirp.ID)
+	if err != nil {
+		respondWithError(w, r, http.StatusInternalServerError, errCodeInternal, "Couldn't delete chirp", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c *apiConfig) loginHandler(w http.ResponseWriter, r *http.Request) {
+	type expected struct {
+		Password string `json:"password"`
+		Email    string `json:"email"`
+	}
+
+	paramsDecoded := expected{}
+	err := decodeJSON(r, &paramsDecoded)
+	if err != nil {
+		respondWithError(w, r, http.StatusBadRequest, errCodeInvalidJSON, "Invalid JSON body", err)
+		return
+	}
+
+	// An invalid address cannot match an account and takes the unknown-user path
+	address, err := email.Normalize(paramsDecoded.Email)
+	if err != nil {
+		address = paramsDecoded.Email
+	}
+
+	now := time.Now().UTC()
+	throttles := c.loginThrottles(r, address)
+	for _, throttle := range throttles {
+		failure, err := c.database.GetLoginFailure(r.Context(), throttle.key)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			respondWithError(w, r, http.StatusInternalServerError, errCodeInternal, "Couldn't check login attempts", err)
+			return
+		}
+		wait := throttle.policy.RetryAfter(int(failure.Failures), failure.LastFailedAt, now)
+		if wait > 0 {
+			c.metrics.logins.Inc("throttled")
+			w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(wait)))
+			respondWithError(w, r, http.StatusTooManyRequests, errCodeRateLimited, "Too many failed login attempts, try again later", nil)
+			return
+		}
+	}
+
+	// Unknown emails are checked against a dummy hash so they take as long
+	// to reject as wrong passwords and get the same response
+	user, err := c.database.GetUserByEmail(r.Context(), address)
+	hashed := user.HashedPassword
+	if err == sql.ErrNoRows {
+		hashed = dummyPasswordHash()
+	} else if err != nil {
+		respondWithError(w, r, http.StatusInternalServerError, errCodeInternal, "Couldn't retrieve user", err)
+		return
+	}
+
+	passwordErr := auth.CheckPassword(hashed, paramsDecoded.Password)
+	if err == sql.ErrNoRows || passwordErr != nil {
+		c.recordLoginFailure(r.Context(), throttles, now)
+		c.metrics.logins.Inc("failure")
+		respondWithError(w, r, http.StatusUnauthorized, errCodeUnauthorized, "Incorrect email or password", nil)
+		return
+	}
+
+	err = c.database.ClearLoginFailures(r.Context(), throttles[0].key)
+	if err != nil {
+		requestLogger(r.Context()).Error("error clearing login failures", "error", err)
+	}
+
+	accessToken, err := auth.MakeJWT(user.ID, c.config.JWTSecret, c.config.AccessTokenTTL)
+	if err != nil {
+		respondWithError(w, r, http.StatusInternalServerError, errCodeInternal, "Couldn't create access token", err)
+		return
+	}
+
+	refreshToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithError(w, r, http.StatusInternalServerError, errCodeInternal, "Couldn't create refresh token", err)
+		return
+	}
+
+	_, err = c.database.CreateRefreshToken(r.Context(), database.CreateRefreshTokenParams{
+		Token:     refreshToken,
+		UserID:    user.ID,
+		ExpiresAt: time.Now().UTC().Add(c.config.RefreshTokenTTL),
+	})
+	if err != nil {
+		respondWithError(w, r, http.StatusInternalServerError, errCodeInternal, "Couldn't store refresh token", err)
+		return
+	}
+
+	type loginResponse struct {
+		userResponse
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	c.metrics.logins.Inc("success")
+	response := loginResponse{
+		userResponse: newUserResponse(user),
+		Token:        accessToken,
+		RefreshToken: refreshToken,
+	}
+
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+const (
+	loginFreeAttempts   = 3
+	loginIPFreeAttempts = 20
+	loginBaseDelay      = time.Second
+	loginMaxDelay       = 30 * time.Second
+)
+
+var dummyPasswordHash = sync.OnceValue(func() string {
+	hashed, err := auth.HashPassword("not-a-real-password")
+	if err != nil {
+		panic(err)
+	}
+	return hashed
+})
+
+type loginThrottle struct {
+	key    string
+	policy auth.LockoutPolicy
+}
+
+func accountThrottleKey(address string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(address))
+}
+
+// loginThrottles returns the failed-login trackers for an attempt: one for
+// the account, whether or not it exists, and a laxer one for the client IP.
+func (c *apiConfig) loginThrottles(r *http.Request, address string) []loginThrottle {
+	return []loginThrottle{
+		{
+			key: accountThrottleKey(address),
+			policy: auth.LockoutPolicy{
+				FreeAttempts:    loginFreeAttempts,
+				BaseDelay:       loginBaseDelay,
+				MaxDelay:        loginMaxDelay,
+				Threshold:       c.config.LoginLockoutThreshold,
+				LockoutDuration: c.config.LoginLockoutDuration,
+			},
+		},
+		{
+			key: "ip:" + c.clientIP(r),
+			policy: auth.LockoutPolicy{
+				FreeAttempts:    loginIPFreeAttempts,
+				BaseDelay:       loginBaseDelay,
+				MaxDelay:        loginMaxDelay,
+				Threshold:       c.config.LoginIPLockoutThreshold,
+				LockoutDuration: c.config.LoginLockoutDuration,
+			},
+		},
+	}
+}
+
+func (c *apiConfig) recordLoginFailure(ctx context.Context, throttles []loginThrottle, now time.Time) {
+	for _, throttle := range throttles {
+		failure, err := c.database.RecordLoginFailure(ctx, database.RecordLoginFailureParams{
+			Key:         throttle.key,
+			FailedAt:    now,
+			WindowStart: now.Add(-c.config.LoginLockoutDuration),
+		})
+		if err != nil {
+			requestLogger(ctx).Error("error recording login failure", "error", err)
+			continue
+		}
+		if int(failure.Failures) == throttle.policy.Threshold {
+			requestLogger(ctx).Warn("login locked after repeated failures", "key", throttle.key, "failures", failure.Failures)
+		}
+	}
+}
+
+func (c *apiConfig) unlockUserHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, r, http.StatusBadRequest, errCodeInvalidID, "Invalid user ID", err)
+		return
+	}
+
+	user, err := c.database.GetUserByID(r.Context(), userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithError(w, r, http.StatusNotFound, errCodeNotFound, "User not found", nil)
+			return
+		}
+		respondWithError(w, r, http.StatusInternalServerError, errCodeInternal, "Couldn't retrieve user", err)
+		return
+	}
+
+	err = c.database.ClearLoginFailures(r.Context(), accountThrottleKey(user.Email))
+	if err != nil {
+		respondWithError(w, r, http.StatusInternalServerError, errCodeInternal, "Couldn't unlock user", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c *apiConfig) refreshHandler(w http.ResponseWriter, r *http.Request) {
+	presented, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, r, http.StatusUnauthorized, errCodeUnauthorized, "Missing or malformed refresh token", err)
+		return
+	}
+
+	stored, err := c.database.GetRefreshToken(r.Context(), presented)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithError(w, r, http.StatusUnauthorized, errCodeUnauthorized, "Invalid refresh token", nil)
+			return
+		}
+		respondWithError(w, r, http.StatusInternalServerError, errCodeInternal, "Couldn't fetch refresh token", err)
+		return
+	}
+
+	if stored.RevokedAt.Valid {
+		if stored.ReplacedBy.Valid {
+			// An already-rotated token was presented again, so the family is compromised
+			c.revokeTokenFamily(r.Context(), stored.UserID)
+		}
+		respondWithError(w, r, http.StatusUnauthorized, errCodeUnauthorized, "Invalid refresh token", nil)
+		return
+	}
+
+	if time.Now().UTC().After(stored.ExpiresAt) {
+		respondWithError(w, r, http.StatusUnauthorized, errCodeUnauthorized, "Invalid refresh token", nil)
+		return
+	}
+
+	newRefreshToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithError(w, r, http.StatusInternalServerError, errCodeInternal, "Couldn't create refresh token", err)
+		return
+	}
+
+	// Rotate and store the replacement together so a failed insert leaves the
+	// presented token usable instead of logging the user out
+	tx, err := c.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, r, http.StatusInternalServerError, errCodeInternal, "Couldn't rotate refresh token", err)
+		return
+	}
+	defer tx.Rollback()
+	queries := c.database.WithTx(tx)
+
+	rotated, err := queries.RotateRefreshToken(r.Context(), database.RotateRefreshTokenParams{
+		Token:      stored.Token,
+		ReplacedBy: sql.NullString{String: newRefreshToken, Valid: true},
+	})
+	if err != nil {
+		respondWithError(w, r, http.StatusInternalServerError, errCodeInternal, "Couldn't rotate refresh token", err)
+		return
+	}
+	if rotated == 0 {
+		// Lost a race with another request presenting the same token
+		tx.Rollback()
+		c.revokeTokenFamily(r.Context(), stored.UserID)
+		respondWithError(w, r, http.StatusUnauthorized, errCodeUnauthorized, "Invalid refresh token", nil)
+		return
+	}
+
+	_, err = queries.CreateRefreshToken(r.Context(), database.CreateRefreshTokenParams{
+		Token:     newRefreshToken,
+		UserID:    stored.UserID,
+		ExpiresAt: time.Now().UTC().Add(c.config.RefreshTokenTTL),
+	})
+	if err != nil {
+		respondWithError(w, r, http.StatusInternalServerError, errCodeInternal, "Couldn't store refresh token", err)
+		return
+	}
+	err = tx.Commit()
+	if err != nil {
+		respondWithError(w, r, http.StatusInternalServerError, errCodeInternal, "Couldn't rotate refresh token", err)
+		return
+	}
+
+	accessToken, err := auth.MakeJWT(stored.UserID, c.config.JWTSecret, c.config.AccessTokenTTL)
+	if err != nil {
+		respondWithError(w, r, http.StatusInternalServerError, errCodeInternal, "Couldn't create access token", err)
+		return
+	}
+
+	type refreshResponse struct {
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	respondWithJSON(w, http.StatusOK, refreshResponse{
+		Token:        accessToken,
+		RefreshToken: newRefreshToken,
+	})
+}
+
+func (c *apiConfig) revokeTokenFamily(ctx context.Context, userID uuid.UUID) {
+	err := c.database.RevokeAllRefreshTokensForUser(ctx, userID)
+	if err != nil {
+		requestLogger(ctx).Error("error revoking refresh tokens", "user_id", userID, "error", err)
+	}
+}
+
+func (c *apiConfig) revokeHandler(w http.ResponseWriter, r *http.Request) {
+	presented, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, r, http.StatusUnauthorized, errCodeUnauthorized, "Missing or malformed refresh token", err)
+		return
+	}
+
+	err = c.database.RevokeRefreshToken(r.Context(), presented)
+	if err != nil {
+		respondWithError(w, r, http.StatusInternalServerError, errCodeInternal, "Couldn't revoke refresh token", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c *apiConfig) polkaWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	type webhookPayload struct {
+		Event string `json:"event"`
+		Data  struct {
+			UserID uuid.UUID `json:"user_id"`
+		} `json:"data"`
+	}
+
+	err := auth.CheckAPIKey(r.Header, c.config.PolkaKey)
+	if err != nil {
+		respondWithError(w, r, http.StatusUnauthorized, errCodeUnauthorized, "Invalid API key", err)
+		return
+	}
+
+	payload := webhookPayload{}
+	err = decodeJSON(r, &payload)
+	if err != nil {
+		respondWithError(w, r, http.StatusBadRequest, errCodeInvalidJSON, "Invalid JSON body", err)
+		return
+	}
+
+	if payload.Event != "user.upgraded" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	_, err = c.database.UpgradeUserToChirpyRed(r.Context(), payload.Data.UserID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithError(w, r, http.StatusNotFound, errCodeNotFound, "User not found", nil)
+			return
+		}
+		respondWithError(w, r, http.StatusInternalServerError, errCodeInternal, "Couldn't upgrade user", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// newMailer builds the configured mailer. The log mailer writes messages to
+// a file, or stdout, instead of delivering them.
+func newMailer(cfg config.Config) (email.Mailer, error) {
+	if cfg.Mailer == "smtp" {
+		return email.NewSMTPMailer(cfg.SMTPAddr, cfg.SMTPUsername, cfg.SMTPPassword, cfg.MailFrom)
+	}
+	if cfg.MailLogFile == "" {
+		return email.NewWriterMailer(cfg.MailFrom, os.Stdout), nil
+	}
+	f, err := os.OpenFile(cfg.MailLogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("error opening mail log: %w", err)
+	}
+	return email.NewWriterMailer(cfg.MailFrom, f), nil
+}
+
+func runMigrate(args []string) int {
+	if len(args) == 0 {
+		fmt.Printf("usage: chirpy migrate <%s> [flags]\n", strings.Join(migrate.Commands, "|"))
+		return 2
+	}
+
+	appConfig, err := config.Load(args[1:], os.LookupEnv)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	if appConfig.DBURL == "" {
+		fmt.Println("error: DB_URL is required to run migrations")
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	db, err := sql.Open("postgres", appConfig.DBURL)
+	if err != nil {
+		fmt.Printf("error: error opening database: %s\n", err)
+		return 1
+	}
+	defer db.Close()
+
+	err = waitForDatabase(ctx, db, appConfig.DBConnectTimeout)
+	if err != nil {
+		fmt.Printf("error: %s\n", err)
+		return 1
+	}
+
+	provider, err := migrate.NewProvider(db, migrationsFS())
+	if err != nil {
+		fmt.Printf("error: %s\n", err)
+		return 1
+	}
+	err = migrate.Run(ctx, provider, args[0], os.Stdout)
+	if err != nil {
+		fmt.Printf("error: %s\n", err)
+		return 1
+	}
+	return 0
+}
+
+func main() {
+	args := os.Args[1:]
+	if len(args) > 0 && args[0] == "migrate" {
+		os.Exit(runMigrate(args[1:]))
+	}
+
+	appConfig, err := config.Load(args, os.LookupEnv)
+	if err == nil {
+		err = appConfig.Validate()
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: appConfig.LogLevel}))
+	slog.SetDefault(logger)
+
+	bannedWords := moderation.DefaultBannedWords
+	if appConfig.BannedWordsFile != "" {
+		bannedWords, err = moderation.LoadWordList(appConfig.BannedWordsFile)
+		if err != nil {
+			logger.Error("error loading banned words", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	mailer, err := newMailer(appConfig)
+	if err != nil {
+		logger.Error("error setting up mailer", "error", err)
+		os.Exit(1)
+	}
+
+	cfg := &apiConfig{
+		config: appConfig,
+		logger: logger,
+		mailer: mailer,
+		contentFilter: moderation.Chain{
+			moderation.NewBannedWords(bannedWords, appConfig.ModerationPolicy),
+		},
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	db, err := sql.Open("postgres", appConfig.DBURL)
+	if err != nil {
+		logger.Error("error opening database", "error", err)
+		os.Exit(1)
+	}
+	err = waitForDatabase(ctx, db, appConfig.DBConnectTimeout)
+	if err != nil {
+		logger.Error("database unavailable", "error", err)
+		db.Close()
+		os.Exit(1)
+	}
+
+	if appConfig.MigrateOnStart {
+		provider, err := migrate.NewProvider(db, migrationsFS())
+		if err == nil {
+			err = migrate.Run(ctx, provider, "up", os.Stdout)
+		}
+		if err != nil {
+			logger.Error("error applying migrations", "error", err)
+			db.Close()
+			os.Exit(1)
+		}
+	}
+
+	cfg.db = db
+	cfg.database = database.New(db)
+	cfg.metrics = newAppMetrics(db)
+
+	var rateLimitStore ratelimit.Store = ratelimit.NewMemoryStore()
+	if appConfig.RateLimitStore == "postgres" {
+		rateLimitStore = ratelimit.NewPostgresStore(cfg.database)
+	}
+	cfg.rateLimiter = ratelimit.NewLimiter(rateLimitStore, appConfig.RateLimits)
+
+	mux := http.NewServeMux()
+	mux.Handle("/app/", cfg.appHandler(staticFS(appConfig.StaticDir)))
+	mux.HandleFunc("GET /api/healthz", cfg.healthCheckHandler)
+	mux.HandleFunc("GET /api/readyz", cfg.readinessHandler)
 	mux.HandleFunc("GET /admin/metrics", cfg.metricsHandler)
+	mux.Handle("GET /metrics", cfg.metrics.registry.Handler())
 	mux.HandleFunc("POST /admin/reset", cfg.resetHandler)
-	mux.HandleFunc("POST /api/users", cfg.addUserHandler)
-	mux.HandleFunc("POST /api/chirps", cfg.addChirpsHandler)
-	mux.HandleFunc("POST /api/login", cfg.loginHandler)
-	mux.HandleFunc("GET /api/chirps", cfg.getAllChirpsHandler)
-	mux.HandleFunc("GET /api/chirps/{chirpID}", cfg.getSingleChirpHandler)
-	serv := http.Server{
-		Handler: mux,
-		Addr:    ":8080",
+	mux.HandleFunc("POST /api/users", cfg.middlewareRateLimit(cfg.addUserHandler))
+	mux.HandleFunc("POST /api/users/verify", cfg.middlewareRateLimit(cfg.verifyEmailHandler))
+	mux.HandleFunc("POST /api/users/verify/resend", cfg.middlewareAuthenticate(cfg.middlewareRateLimit(cfg.resendVerificationHandler)))
+	mux.HandleFunc("PUT /api/users", cfg.middlewareAuthenticate(cfg.middlewareRateLimit(cfg.updateUserHandler)))
+	mux.HandleFunc("POST /api/chirps", cfg.middlewareAuthenticate(cfg.middlewareRateLimit(cfg.addChirpsHandler)))
+	mux.HandleFunc("POST /api/login", cfg.middlewareRateLimit(cfg.loginHandler))
+	mux.HandleFunc("POST /api/refresh", cfg.middlewareRateLimit(cfg.refreshHandler))
+	mux.HandleFunc("POST /api/password/forgot", cfg.middlewareRateLimit(cfg.forgotPasswordHandler))
+	mux.HandleFunc("POST /api/password/reset", cfg.middlewareRateLimit(cfg.resetPasswordHandler))
+	mux.HandleFunc("POST /api/revoke", cfg.middlewareRateLimit(cfg.revokeHandler))
+	mux.HandleFunc("GET /api/limits", cfg.limitsHandler)
+	mux.HandleFunc("GET /api/chirps", cfg.middlewareOptionalAuthenticate(cfg.middlewareRateLimit(cfg.getAllChirpsHandler)))
+	mux.HandleFunc("GET /api/chirps/{chirpID}", cfg.middlewareOptionalAuthenticate(cfg.middlewareRateLimit(cfg.getSingleChirpHandler)))
+	mux.HandleFunc("POST /api/chirps/{chirpID}/likes", cfg.middlewareAuthenticate(cfg.middlewareRateLimit(cfg.likeChirpHandler)))
+	mux.HandleFunc("DELETE /api/chirps/{chirpID}/likes", cfg.middlewareAuthenticate(cfg.middlewareRateLimit(cfg.unlikeChirpHandler)))
+	mux.HandleFunc("GET /api/users/{userID}/likes", cfg.middlewareOptionalAuthenticate(cfg.middlewareRateLimit(cfg.getUserLikesHandler)))
+	mux.HandleFunc("PUT /api/chirps/{chirpID}", cfg.middlewareAuthenticate(cfg.middlewareRateLimit(cfg.editChirpHandler)))
+	mux.HandleFunc("GET /api/chirps/{chirpID}/thread", cfg.middlewareOptionalAuthenticate(cfg.middlewareRateLimit(cfg.getChirpThreadHandler)))
+	mux.HandleFunc("GET /api/chirps/{chirpID}/revisions", cfg.middlewareOptionalAuthenticate(cfg.middlewareRateLimit(cfg.getChirpRevisionsHandler)))
+	mux.HandleFunc("DELETE /api/chirps/{chirpID}", cfg.middlewareAuthenticate(cfg.middlewareRateLimit(cfg.deleteChirpHandler)))
+	mux.HandleFunc("DELETE /admin/chirps/{chirpID}", cfg.middlewareAdmin(cfg.adminDeleteChirpHandler))
+	mux.HandleFunc("POST /admin/users/{userID}/unlock", cfg.middlewareAdmin(cfg.unlockUserHandler))
+	mux.HandleFunc("POST /api/polka/webhooks", cfg.polkaWebhookHandler)
+	serv := &http.Server{
+		Handler:           cfg.middlewareLogging(cfg.metrics.http.Middleware(middlewareJSONErrors(mux))),
+		Addr:              appConfig.ListenAddr,
+		ReadHeaderTimeout: appConfig.ReadHeaderTimeout,
+		ReadTimeout:       appConfig.ReadTimeout,
+		WriteTimeout:      appConfig.WriteTimeout,
+		IdleTimeout:       appConfig.IdleTimeout,
+	}
+
+	ln, err := net.Listen("tcp", appConfig.ListenAddr)
+	if err != nil {
+		logger.Error("error listening", "addr", appConfig.ListenAddr, "error", err)
+		os.Exit(1)
 	}
 
-	err = serv.ListenAndServe()
+	logger.Info("listening", "addr", ln.Addr().String())
+	err = cfg.serve(ctx, serv, ln, db)
 	if err != nil {
-		fmt.Println("error: server error")
+		logger.Error("server stopped", "error", err)
+		os.Exit(1)
+	}
+}
+
+// serve runs srv on ln until ctx is cancelled, then fails health checks,
+// drains in-flight requests within the configured deadline and closes the
+// database.
+func (c *apiConfig) serve(ctx context.Context, srv *http.Server, ln net.Listener, db *sql.DB) error {
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.Serve(ln)
+	}()
+
+	select {
+	case err := <-serveErr:
+		db.Close()
+		return fmt.Errorf("server error: %w", err)
+	case <-ctx.Done():
+	}
+
+	c.logger.Info("shutting down: draining in-flight requests")
+	c.backgroundMu.Lock()
+	c.draining.Store(true)
+	c.backgroundMu.Unlock()
+	time.Sleep(c.config.ShutdownDelay)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), c.config.ShutdownTimeout)
+	defer cancel()
+	shutdownErr := srv.Shutdown(shutdownCtx)
+	if err := <-serveErr; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		shutdownErr = errors.Join(shutdownErr, err)
+	}
+
+	// Let work started by finished requests use the database before it closes
+	backgroundDone := make(chan struct{})
+	go func() {
+		c.background.Wait()
+		close(backgroundDone)
+	}()
+	select {
+	case <-backgroundDone:
+	case <-shutdownCtx.Done():
+		shutdownErr = errors.Join(shutdownErr, errors.New("background work did not finish before the shutdown deadline"))
+	}
+
+	if err := db.Close(); err != nil {
+		shutdownErr = errors.Join(shutdownErr, fmt.Errorf("error closing database: %w", err))
+	}
+	if shutdownErr != nil {
+		return fmt.Errorf("shutdown error: %w", shutdownErr)
 	}
+	return nil
 }
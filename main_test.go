@@ -0,0 +1,396 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/YoavIsaacs/chirpy/internal/auth"
+	"github.com/YoavIsaacs/chirpy/internal/config"
+	"github.com/YoavIsaacs/chirpy/internal/database"
+	"github.com/YoavIsaacs/chirpy/internal/migrate"
+	"github.com/YoavIsaacs/chirpy/internal/moderation"
+	"github.com/YoavIsaacs/chirpy/internal/ratelimit"
+	"github.com/google/uuid"
+)
+
+func newTestConfig(db *fakeDB) *apiConfig {
+	sqlDB := db.sqlDB()
+	return &apiConfig{
+		db:       sqlDB,
+		database: database.New(sqlDB),
+		config: config.Config{
+			JWTSecret:        "test-secret-key",
+			AccessTokenTTL:   time.Hour,
+			RefreshTokenTTL:  60 * 24 * time.Hour,
+			Platform:         "prod",
+			MaxChirpLength:   140,
+			ChirpURLLength:   23,
+			ChirpEditWindow:  15 * time.Minute,
+			ReadinessTimeout: time.Second,
+
+			LoginLockoutThreshold:   5,
+			LoginIPLockoutThreshold: 50,
+			LoginLockoutDuration:    15 * time.Minute,
+			EmailVerificationTTL:    24 * time.Hour,
+			PasswordResetTTL:        time.Hour,
+		},
+		contentFilter: moderation.Chain{},
+		metrics:       newAppMetrics(sqlDB),
+		logger:        slog.New(slog.NewJSONHandler(io.Discard, nil)),
+		rateLimiter:   ratelimit.NewLimiter(ratelimit.NewMemoryStore(), nil),
+		mailer:        &fakeMailer{},
+	}
+}
+
+func TestChirpCursor(t *testing.T) {
+	chirp := database.Chirp{
+		ID:        uuid.New(),
+		CreatedAt: time.Date(2025, 3, 14, 15, 9, 26, 535897000, time.UTC),
+	}
+
+	t.Run("Round trips created_at and id", func(t *testing.T) {
+		createdAt, id, err := decodeChirpCursor(encodeChirpCursor(chirp))
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if !createdAt.Equal(chirp.CreatedAt) {
+			t.Fatalf("Expected created_at %v, got %v", chirp.CreatedAt, createdAt)
+		}
+		if id != chirp.ID {
+			t.Fatalf("Expected id %v, got %v", chirp.ID, id)
+		}
+	})
+
+	t.Run("Rejects garbage", func(t *testing.T) {
+		for _, cursor := range []string{"not-base64!", "bm8tc2VwYXJhdG9y", "eHx5"} {
+			if _, _, err := decodeChirpCursor(cursor); err == nil {
+				t.Fatalf("Expected error for cursor '%s', got nil", cursor)
+			}
+		}
+	})
+}
+
+func TestUserRoutesNeverExposePasswordHash(t *testing.T) {
+	const password = "04234"
+	hashed, err := auth.HashPassword(password)
+	if err != nil {
+		t.Fatalf("Failed to hash password: %v", err)
+	}
+	user := database.User{
+		ID:             uuid.New(),
+		CreatedAt:      time.Now().UTC(),
+		UpdatedAt:      time.Now().UTC(),
+		Email:          "walt@breakingbad.com",
+		HashedPassword: hashed,
+	}
+
+	db := newFakeDB(t)
+	db.on("CreateUser", userRow(user))
+	db.on("GetUserByID", userRow(user))
+	db.on("GetUserByEmail", userRow(user))
+	db.on("UpdateUser", userRow(user))
+	db.on("GetLoginFailure", noLoginFailures)
+	db.on("ClearLoginFailures", func([]driver.NamedValue) fakeResult {
+		return fakeResult{}
+	})
+	db.on("CreateEmailVerificationToken", func([]driver.NamedValue) fakeResult {
+		return fakeResult{}
+	})
+	db.on("CreateRefreshToken", func(args []driver.NamedValue) fakeResult {
+		return fakeResult{
+			Columns: []string{"token", "created_at", "updated_at", "user_id", "expires_at", "revoked_at", "replaced_by"},
+			Rows:    [][]driver.Value{{args[0].Value, user.CreatedAt, user.CreatedAt, user.ID.String(), user.CreatedAt, nil, nil}},
+		}
+	})
+	db.on("RevokeAllRefreshTokensForUser", func([]driver.NamedValue) fakeResult {
+		return fakeResult{}
+	})
+
+	cfg := newTestConfig(db)
+
+	routes := []struct {
+		name    string
+		handler http.HandlerFunc
+		method  string
+		body    string
+		auth    bool
+	}{
+		{name: "Signup", handler: cfg.addUserHandler, method: http.MethodPost, body: `{"email":"walt@breakingbad.com","password":"04234"}`},
+		{name: "Login", handler: cfg.loginHandler, method: http.MethodPost, body: `{"email":"walt@breakingbad.com","password":"04234"}`},
+		{name: "Update", handler: cfg.middlewareAuthenticate(cfg.updateUserHandler), method: http.MethodPut, body: `{"password":"new-password","current_password":"04234"}`, auth: true},
+	}
+
+	for _, route := range routes {
+		t.Run(route.name, func(t *testing.T) {
+			req := httptest.NewRequest(route.method, "/api/users", strings.NewReader(route.body))
+			if route.auth {
+				token, err := auth.MakeJWT(user.ID, cfg.config.JWTSecret, time.Minute)
+				if err != nil {
+					t.Fatalf("Failed to create token: %v", err)
+				}
+				req.Header.Set("Authorization", "Bearer "+token)
+			}
+			rec := httptest.NewRecorder()
+
+			route.handler(rec, req)
+
+			if rec.Code >= 300 {
+				t.Fatalf("Expected success status, got %d", rec.Code)
+			}
+			body := rec.Body.String()
+			if strings.Contains(body, hashed) {
+				t.Fatalf("Response contains the password hash: %s", body)
+			}
+			if strings.Contains(body, "$2a$") {
+				t.Fatalf("Response contains bcrypt material: %s", body)
+			}
+			if strings.Contains(body, `"password"`) || strings.Contains(body, "hashed_password") {
+				t.Fatalf("Response contains a password field: %s", body)
+			}
+		})
+	}
+}
+
+func TestUserResponseHasNoPasswordField(t *testing.T) {
+	data, err := json.Marshal(newUserResponse(database.User{HashedPassword: "$2a$10$secret"}))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if strings.Contains(string(data), "secret") || strings.Contains(string(data), "password") {
+		t.Fatalf("Expected no password material, got %s", data)
+	}
+}
+
+func TestAppDoesNotServeRepository(t *testing.T) {
+	cfg := newTestConfig(newFakeDB(t))
+	handler := cfg.appHandler(staticFS(""))
+
+	for _, path := range []string{"/app/.env", "/app/sql/", "/app/sql/schema/001_users.sql", "/app/go.mod", "/app/main.go", "/app/.git/config"} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusNotFound {
+			t.Fatalf("Expected %s to be unreachable, got status %d", path, rec.Code)
+		}
+	}
+
+	for _, path := range []string{"/app/", "/app/assets/logo.png"} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected %s to be served, got status %d", path, rec.Code)
+		}
+	}
+}
+
+func TestServeDrainsOnShutdown(t *testing.T) {
+	cfg := newTestConfig(newFakeDB(t))
+	cfg.config.ShutdownTimeout = 5 * time.Second
+
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/healthz", cfg.healthCheckHandler)
+	mux.HandleFunc("GET /slow", func(w http.ResponseWriter, r *http.Request) {
+		close(entered)
+		<-release
+		w.Write([]byte("done"))
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	served := make(chan error, 1)
+	go func() {
+		served <- cfg.serve(ctx, &http.Server{Handler: mux}, ln, newFakeDB(t).sqlDB())
+	}()
+
+	slowStatus := make(chan int, 1)
+	go func() {
+		resp, err := http.Get("http://" + ln.Addr().String() + "/slow")
+		if err != nil {
+			slowStatus <- 0
+			return
+		}
+		resp.Body.Close()
+		slowStatus <- resp.StatusCode
+	}()
+
+	<-entered
+	cancel()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !cfg.draining.Load() {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected server to start draining")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	rec := httptest.NewRecorder()
+	cfg.healthCheckHandler(rec, httptest.NewRequest(http.MethodGet, "/api/healthz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected health check to fail while draining, got %d", rec.Code)
+	}
+
+	close(release)
+	if status := <-slowStatus; status != http.StatusOK {
+		t.Fatalf("Expected in-flight request to complete, got status %d", status)
+	}
+	if err := <-served; err != nil {
+		t.Fatalf("Expected clean shutdown, got %v", err)
+	}
+}
+
+func TestReadiness(t *testing.T) {
+	t.Run("Ready with migration version", func(t *testing.T) {
+		db := newFakeDB(t)
+		db.on(migrationVersionQuery, func([]driver.NamedValue) fakeResult {
+			return fakeResult{Columns: []string{"coalesce"}, Rows: [][]driver.Value{{int64(7)}}}
+		})
+		cfg := newTestConfig(db)
+
+		rec := httptest.NewRecorder()
+		cfg.readinessHandler(rec, httptest.NewRequest(http.MethodGet, "/api/readyz", nil))
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+		}
+		body := rec.Body.String()
+		if !strings.Contains(body, `"status":"ready"`) || !strings.Contains(body, `"version":7`) {
+			t.Fatalf("Unexpected readiness body: %s", body)
+		}
+	})
+
+	t.Run("Unknown migration version stays ready", func(t *testing.T) {
+		db := newFakeDB(t)
+		db.on(migrationVersionQuery, func([]driver.NamedValue) fakeResult {
+			return fakeResult{Err: errors.New(`relation "goose_db_version" does not exist for role chirpy_admin`)}
+		})
+		cfg := newTestConfig(db)
+
+		rec := httptest.NewRecorder()
+		cfg.readinessHandler(rec, httptest.NewRequest(http.MethodGet, "/api/readyz", nil))
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+		}
+		body := rec.Body.String()
+		if !strings.Contains(body, `"migrations":{"status":"unknown"`) || strings.Contains(body, "chirpy_admin") {
+			t.Fatalf("Expected a generic unknown migration status, got %s", body)
+		}
+	})
+
+	t.Run("Not ready when database is down", func(t *testing.T) {
+		db := newFakeDB(t)
+		db.connectErr = errors.New("connection refused")
+		cfg := newTestConfig(db)
+
+		rec := httptest.NewRecorder()
+		cfg.readinessHandler(rec, httptest.NewRequest(http.MethodGet, "/api/readyz", nil))
+
+		if rec.Code != http.StatusServiceUnavailable {
+			t.Fatalf("Expected status %d, got %d", http.StatusServiceUnavailable, rec.Code)
+		}
+		body := rec.Body.String()
+		if !strings.Contains(body, `"database":{"status":"down"`) {
+			t.Fatalf("Expected the database to be reported down, got %s", body)
+		}
+		if strings.Contains(body, "connection refused") {
+			t.Fatalf("Expected driver errors to stay out of the body, got %s", body)
+		}
+	})
+}
+
+func TestWaitForDatabase(t *testing.T) {
+	db := newFakeDB(t)
+	db.connectErr = errors.New("connection refused")
+
+	err := waitForDatabase(context.Background(), db.sqlDB(), 300*time.Millisecond)
+	if err == nil {
+		t.Fatal("Expected error when database never comes up, got nil")
+	}
+
+	up := newFakeDB(t)
+	if err := waitForDatabase(context.Background(), up.sqlDB(), time.Second); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+	entries, err := os.ReadDir("sql/schema")
+	if err != nil {
+		t.Fatalf("Failed to read schema directory: %v", err)
+	}
+
+	provider, err := migrate.NewProvider(newFakeDB(t).sqlDB(), migrationsFS())
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	sources := provider.ListSources()
+	if len(sources) != len(entries) {
+		t.Fatalf("Expected %d embedded migrations, got %d", len(entries), len(sources))
+	}
+	for i, source := range sources {
+		if source.Version != int64(i+1) {
+			t.Fatalf("Expected migration version %d, got %d (%s)", i+1, source.Version, source.Path)
+		}
+		contents, err := fs.ReadFile(migrationsFS(), source.Path)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", source.Path, err)
+		}
+		if !strings.Contains(string(contents), "-- +goose Up") || !strings.Contains(string(contents), "-- +goose Down") {
+			t.Fatalf("Expected %s to have goose Up and Down sections", source.Path)
+		}
+	}
+
+	if err := migrate.Run(context.Background(), provider, "sideways", io.Discard); err == nil {
+		t.Fatal("Expected error for unknown migrate command, got nil")
+	}
+}
+
+func TestMetricsShareRegistry(t *testing.T) {
+	cfg := newTestConfig(newFakeDB(t))
+	app := cfg.appHandler(staticFS(""))
+	for range 2 {
+		app.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/app/", nil))
+	}
+
+	rec := httptest.NewRecorder()
+	cfg.metricsHandler(rec, httptest.NewRequest(http.MethodGet, "/admin/metrics", nil))
+	if !strings.Contains(rec.Body.String(), "visited 2 times") {
+		t.Fatalf("Expected admin page to report 2 visits, got: %s", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	cfg.metrics.registry.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Fatalf("Expected Prometheus text content type, got '%s'", ct)
+	}
+	for _, want := range []string{
+		"chirpy_fileserver_hits_total 2\n",
+		"chirpy_chirps_created_total 0\n",
+		`chirpy_logins_total{result="failure"} 0` + "\n",
+		"# TYPE chirpy_db_open_connections gauge\n",
+		"# TYPE chirpy_db_wait_count_total counter\n",
+	} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Fatalf("Expected /metrics to contain %q, got:\n%s", want, rec.Body.String())
+		}
+	}
+}
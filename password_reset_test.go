@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/YoavIsaacs/chirpy/internal/auth"
+	"github.com/YoavIsaacs/chirpy/internal/database"
+	"github.com/YoavIsaacs/chirpy/internal/email"
+	"github.com/google/uuid"
+)
+
+func TestForgotPasswordDoesNotRevealAccounts(t *testing.T) {
+	user := database.User{ID: uuid.New(), Email: "skyler@example.com"}
+
+	db := newFakeDB(t)
+	db.on("GetUserByEmail", func(args []driver.NamedValue) fakeResult {
+		if args[0].Value == user.Email {
+			return userRow(user)(args)
+		}
+		return fakeResult{Columns: userColumns}
+	})
+	var storedHashes []string
+	db.on("CreatePasswordResetToken", func(args []driver.NamedValue) fakeResult {
+		storedHashes = append(storedHashes, args[0].Value.(string))
+		return fakeResult{}
+	})
+	cfg := newTestConfig(db)
+
+	// Deliver through the log mailer's file sink, as a local setup would
+	mailLog := filepath.Join(t.TempDir(), "mail.log")
+	cfg.config.MailLogFile = mailLog
+	mailer, err := newMailer(cfg.config)
+	if err != nil {
+		t.Fatalf("Failed to create mailer: %v", err)
+	}
+	cfg.mailer = mailer
+
+	var bodies []string
+	for _, address := range []string{" Skyler@Example.com", "nobody@example.com", "not-an-email"} {
+		rec := httptest.NewRecorder()
+		cfg.forgotPasswordHandler(rec, httptest.NewRequest(http.MethodPost, "/api/password/forgot", strings.NewReader(`{"email":"`+address+`"}`)))
+		if rec.Code != http.StatusAccepted {
+			t.Fatalf("Expected 202 for %q, got %d", address, rec.Code)
+		}
+		bodies = append(bodies, rec.Body.String())
+	}
+	if bodies[0] != bodies[1] || bodies[1] != bodies[2] {
+		t.Fatalf("Expected identical responses, got %q", bodies)
+	}
+	cfg.background.Wait()
+
+	sent, err := os.ReadFile(mailLog)
+	if err != nil {
+		t.Fatalf("Failed to read mail log: %v", err)
+	}
+	if strings.Count(string(sent), "To: ") != 1 || !strings.Contains(string(sent), "To: skyler@example.com\r\n") {
+		t.Fatalf("Expected exactly one email to the account holder, got:\n%s", sent)
+	}
+	token := mailedTokenPattern.FindString(string(sent))
+	if len(storedHashes) != 1 || storedHashes[0] != auth.HashToken(token) {
+		t.Fatalf("Expected only the hash of the mailed token to be stored, got %v", storedHashes)
+	}
+}
+
+// blockingMailer holds every message until release is closed.
+type blockingMailer struct {
+	fakeMailer
+	release chan struct{}
+}
+
+func (m *blockingMailer) Send(ctx context.Context, msg email.Message) error {
+	<-m.release
+	return m.fakeMailer.Send(ctx, msg)
+}
+
+func TestForgotPasswordDoesNotWaitForMailer(t *testing.T) {
+	user := database.User{ID: uuid.New(), Email: "skyler@example.com"}
+
+	db := newFakeDB(t)
+	db.on("GetUserByEmail", userRow(user))
+	db.on("CreatePasswordResetToken", func([]driver.NamedValue) fakeResult {
+		return fakeResult{}
+	})
+	cfg := newTestConfig(db)
+	mailer := &blockingMailer{release: make(chan struct{})}
+	cfg.mailer = mailer
+
+	answered := make(chan int, 1)
+	go func() {
+		rec := httptest.NewRecorder()
+		cfg.forgotPasswordHandler(rec, httptest.NewRequest(http.MethodPost, "/api/password/forgot", strings.NewReader(`{"email":"`+user.Email+`"}`)))
+		answered <- rec.Code
+	}()
+
+	select {
+	case status := <-answered:
+		if status != http.StatusAccepted {
+			t.Fatalf("Expected 202, got %d", status)
+		}
+	case <-time.After(time.Second):
+		close(mailer.release)
+		t.Fatal("Expected the handler to answer before the email is sent")
+	}
+
+	close(mailer.release)
+	cfg.background.Wait()
+	if len(mailer.sent) != 1 || mailer.sent[0].To != user.Email {
+		t.Fatalf("Expected the reset email to be sent afterwards, got %+v", mailer.sent)
+	}
+}
+
+func TestForgotPasswordWhileDraining(t *testing.T) {
+	db := newFakeDB(t)
+	db.on("GetUserByEmail", func([]driver.NamedValue) fakeResult {
+		t.Error("Expected no lookup once the server is draining")
+		return fakeResult{Columns: userColumns}
+	})
+	cfg := newTestConfig(db)
+	cfg.draining.Store(true)
+
+	rec := httptest.NewRecorder()
+	cfg.forgotPasswordHandler(rec, httptest.NewRequest(http.MethodPost, "/api/password/forgot", strings.NewReader(`{"email":"skyler@example.com"}`)))
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("Expected 202, got %d", rec.Code)
+	}
+	cfg.background.Wait()
+	if mailer := cfg.mailer.(*fakeMailer); len(mailer.sent) != 0 {
+		t.Fatalf("Expected no email once the server is draining, got %+v", mailer.sent)
+	}
+}
+
+func TestResetPassword(t *testing.T) {
+	user := database.User{ID: uuid.New(), Email: "skyler@example.com"}
+	validToken := "a-valid-token"
+
+	tests := []struct {
+		name       string
+		body       string
+		revokeErr  error
+		wantStatus int
+	}{
+		{name: "Missing token", body: `{"password":"new-password"}`, wantStatus: http.StatusBadRequest},
+		{name: "Missing password", body: `{"token":"` + validToken + `"}`, wantStatus: http.StatusBadRequest},
+		{name: "Unknown, used or expired token", body: `{"token":"something-else","password":"new-password"}`, wantStatus: http.StatusBadRequest},
+		{name: "Valid token", body: `{"token":"` + validToken + `","password":"new-password"}`, wantStatus: http.StatusNoContent},
+		{name: "Sessions not revoked", body: `{"token":"` + validToken + `","password":"new-password"}`, revokeErr: errors.New("connection reset"), wantStatus: http.StatusInternalServerError},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			db := newFakeDB(t)
+			db.on("ConsumePasswordResetToken", func(args []driver.NamedValue) fakeResult {
+				if args[0].Value != auth.HashToken(validToken) {
+					return fakeResult{Columns: []string{"user_id"}}
+				}
+				return fakeResult{Columns: []string{"user_id"}, Rows: [][]driver.Value{{user.ID.String()}}}
+			})
+			var newHash string
+			db.on("UpdateUserPassword", func(args []driver.NamedValue) fakeResult {
+				newHash = args[1].Value.(string)
+				updated := user
+				updated.HashedPassword = newHash
+				return userRow(updated)(args)
+			})
+			okExec := func([]driver.NamedValue) fakeResult { return fakeResult{} }
+			db.on("InvalidatePasswordResetTokensForUser", okExec)
+			db.on("RevokeAllRefreshTokensForUser", func([]driver.NamedValue) fakeResult {
+				return fakeResult{Err: tc.revokeErr}
+			})
+			var cleared string
+			db.on("ClearLoginFailures", func(args []driver.NamedValue) fakeResult {
+				cleared = args[0].Value.(string)
+				return fakeResult{}
+			})
+			cfg := newTestConfig(db)
+
+			rec := httptest.NewRecorder()
+			cfg.resetPasswordHandler(rec, httptest.NewRequest(http.MethodPost, "/api/password/reset", strings.NewReader(tc.body)))
+			if rec.Code != tc.wantStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tc.wantStatus, rec.Code, rec.Body.String())
+			}
+			if tc.revokeErr != nil {
+				if slices.Contains(db.calls, "COMMIT") || !slices.Contains(db.calls, "ROLLBACK") || cleared != "" {
+					t.Fatalf("Expected the reset to be rolled back, got %v", db.calls)
+				}
+				return
+			}
+			if tc.wantStatus != http.StatusNoContent {
+				if slices.Contains(db.calls, "UpdateUserPassword") {
+					t.Fatal("Expected the password to be left alone")
+				}
+				return
+			}
+
+			if err := auth.CheckPassword(newHash, "new-password"); err != nil {
+				t.Fatalf("Expected the new password to be stored hashed: %v", err)
+			}
+			for _, want := range []string{"InvalidatePasswordResetTokensForUser", "RevokeAllRefreshTokensForUser"} {
+				if i := slices.Index(db.calls, want); i < 0 || i > slices.Index(db.calls, "COMMIT") {
+					t.Fatalf("Expected %s to be called before committing, got %v", want, db.calls)
+				}
+			}
+			if cleared != "account:skyler@example.com" {
+				t.Fatalf("Expected the account lockout to be cleared, got %q", cleared)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/YoavIsaacs/chirpy/internal/auth"
+	"github.com/YoavIsaacs/chirpy/internal/ratelimit"
+	"github.com/google/uuid"
+)
+
+func TestRateLimitMiddleware(t *testing.T) {
+	cfg := newTestConfig(newFakeDB(t))
+	cfg.rateLimiter = ratelimit.NewLimiter(ratelimit.NewMemoryStore(), map[string]ratelimit.Limit{
+		"POST /login": {Requests: 2, Per: time.Minute},
+		"POST /post":  {Requests: 1, Per: time.Minute},
+	})
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) }
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /login", cfg.middlewareRateLimit(ok))
+	mux.HandleFunc("POST /post", cfg.middlewareAuthenticate(cfg.middlewareRateLimit(ok)))
+	mux.HandleFunc("GET /open", cfg.middlewareRateLimit(ok))
+
+	send := func(method, path, remoteAddr, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.RemoteAddr = remoteAddr
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for i, wantRemaining := range []string{"1", "0"} {
+		rec := send(http.MethodPost, "/login", "10.0.0.1:1234", "")
+		if rec.Code != http.StatusNoContent || rec.Header().Get("X-RateLimit-Remaining") != wantRemaining {
+			t.Fatalf("Login %d: expected success with %s remaining, got %d %v", i, wantRemaining, rec.Code, rec.Header())
+		}
+	}
+	rec := send(http.MethodPost, "/login", "10.0.0.1:5678", "")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected 429 once the IP is out of tokens, got %d", rec.Code)
+	}
+	if rec.Header().Get("Retry-After") != "30" || rec.Header().Get("X-RateLimit-Limit") != "2" || rec.Header().Get("X-RateLimit-Reset") != "60" {
+		t.Fatalf("Unexpected rate limit headers: %v", rec.Header())
+	}
+	if rec := send(http.MethodPost, "/login", "10.0.0.2:1234", ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("Expected a different IP to have its own bucket, got %d", rec.Code)
+	}
+
+	tokenFor := func(userID uuid.UUID) string {
+		token, err := auth.MakeJWT(userID, cfg.config.JWTSecret, time.Minute)
+		if err != nil {
+			t.Fatalf("Failed to make token: %v", err)
+		}
+		return token
+	}
+	alice, bob := tokenFor(uuid.New()), tokenFor(uuid.New())
+	if rec := send(http.MethodPost, "/post", "10.0.0.3:1", alice); rec.Code != http.StatusNoContent {
+		t.Fatalf("Expected first post to succeed, got %d", rec.Code)
+	}
+	if rec := send(http.MethodPost, "/post", "10.0.0.4:1", alice); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected user to be limited across IPs, got %d", rec.Code)
+	}
+	if rec := send(http.MethodPost, "/post", "10.0.0.3:1", bob); rec.Code != http.StatusNoContent {
+		t.Fatalf("Expected another user on the same IP to be allowed, got %d", rec.Code)
+	}
+
+	for range 5 {
+		rec := send(http.MethodGet, "/open", "10.0.0.1:1", "")
+		if rec.Code != http.StatusNoContent || rec.Header().Get("X-RateLimit-Limit") != "" {
+			t.Fatalf("Expected route without a limit to pass untouched, got %d %v", rec.Code, rec.Header())
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	cfg := newTestConfig(newFakeDB(t))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "192.0.2.1:4000"
+	req.Header.Set("X-Forwarded-For", "203.0.113.9, 198.51.100.7")
+
+	if got := cfg.clientIP(req); got != "192.0.2.1" {
+		t.Fatalf("Expected forwarded header to be ignored by default, got '%s'", got)
+	}
+	cfg.config.TrustProxyHeaders = true
+	if got := cfg.clientIP(req); got != "198.51.100.7" {
+		t.Fatalf("Expected the proxy-added hop, got '%s'", got)
+	}
+}
+
+func TestRateLimitPostgresStore(t *testing.T) {
+	db := newFakeDB(t)
+	cfg := newTestConfig(db)
+	store := ratelimit.NewPostgresStore(cfg.database)
+	limit := ratelimit.Limit{Requests: 2, Per: 10 * time.Second}
+	now := time.Unix(1700000000, 0)
+
+	db.on("DeleteExpiredRateLimits", func([]driver.NamedValue) fakeResult { return fakeResult{} })
+	db.on("TakeRateLimitToken", func(args []driver.NamedValue) fakeResult {
+		if args[0].Value != "k" || args[1].Value != now.UnixNano() || args[2].Value != int64(5*time.Second) || args[3].Value != int64(5*time.Second) {
+			t.Errorf("Unexpected token arguments: %v", args)
+		}
+		return fakeResult{Columns: []string{"tat"}, Rows: [][]driver.Value{{now.Add(5 * time.Second).UnixNano()}}}
+	})
+
+	res, err := store.Take(t.Context(), "k", limit, now)
+	if err != nil || !res.Allowed || res.Remaining != 1 {
+		t.Fatalf("Expected allowed request with 1 remaining, got %+v, %v", res, err)
+	}
+
+	// A denied take leaves the row alone and returns nothing
+	db.on("TakeRateLimitToken", func([]driver.NamedValue) fakeResult { return fakeResult{Columns: []string{"tat"}} })
+	db.on("GetRateLimitTAT", func([]driver.NamedValue) fakeResult {
+		return fakeResult{Columns: []string{"tat"}, Rows: [][]driver.Value{{now.Add(8 * time.Second).UnixNano()}}}
+	})
+	res, err = store.Take(t.Context(), "k", limit, now)
+	if err != nil || res.Allowed || res.RetryAfter != 3*time.Second {
+		t.Fatalf("Expected denial with 3s retry, got %+v, %v", res, err)
+	}
+}
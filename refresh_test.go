@@ -0,0 +1,157 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/YoavIsaacs/chirpy/internal/auth"
+	"github.com/google/uuid"
+)
+
+var refreshTokenColumns = []string{"token", "created_at", "updated_at", "user_id", "expires_at", "revoked_at", "replaced_by"}
+
+func TestRefresh(t *testing.T) {
+	userID := uuid.New()
+	now := time.Now().UTC()
+
+	tests := []struct {
+		name          string
+		authorization string
+		expiresAt     time.Time
+		revokedAt     driver.Value
+		replacedBy    driver.Value
+		unknown       bool
+		lostRace      bool
+		insertErr     error
+		wantStatus    int
+		wantFamily    bool
+	}{
+		{name: "Valid token", expiresAt: now.Add(time.Hour), wantStatus: http.StatusOK},
+		{name: "Already rotated", expiresAt: now.Add(time.Hour), revokedAt: now, replacedBy: "newer-token", wantStatus: http.StatusUnauthorized, wantFamily: true},
+		{name: "Revoked", expiresAt: now.Add(time.Hour), revokedAt: now, wantStatus: http.StatusUnauthorized},
+		{name: "Expired", expiresAt: now.Add(-time.Minute), wantStatus: http.StatusUnauthorized},
+		{name: "Unknown token", unknown: true, wantStatus: http.StatusUnauthorized},
+		{name: "Missing header", authorization: "none", wantStatus: http.StatusUnauthorized},
+		{name: "Rotated concurrently", expiresAt: now.Add(time.Hour), lostRace: true, wantStatus: http.StatusUnauthorized, wantFamily: true},
+		{name: "Replacement not stored", expiresAt: now.Add(time.Hour), insertErr: errors.New("disk full"), wantStatus: http.StatusInternalServerError},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			db := newFakeDB(t)
+			db.on("GetRefreshToken", func(args []driver.NamedValue) fakeResult {
+				if tc.unknown {
+					return fakeResult{Columns: refreshTokenColumns}
+				}
+				return fakeResult{
+					Columns: refreshTokenColumns,
+					Rows:    [][]driver.Value{{args[0].Value, now, now, userID.String(), tc.expiresAt, tc.revokedAt, tc.replacedBy}},
+				}
+			})
+			var replacedBy any
+			db.on("RotateRefreshToken", func(args []driver.NamedValue) fakeResult {
+				if args[0].Value != "old-token" {
+					t.Errorf("Expected the presented token to be rotated, got %v", args[0].Value)
+				}
+				replacedBy = args[1].Value
+				if tc.lostRace {
+					return fakeResult{}
+				}
+				return fakeResult{RowsAffected: 1}
+			})
+			var created any
+			db.on("CreateRefreshToken", func(args []driver.NamedValue) fakeResult {
+				if tc.insertErr != nil {
+					return fakeResult{Err: tc.insertErr}
+				}
+				created = args[0].Value
+				return fakeResult{
+					Columns: refreshTokenColumns,
+					Rows:    [][]driver.Value{{args[0].Value, now, now, args[1].Value, args[2].Value, nil, nil}},
+				}
+			})
+			var family any
+			db.on("RevokeAllRefreshTokensForUser", func(args []driver.NamedValue) fakeResult {
+				family = args[0].Value
+				return fakeResult{}
+			})
+			cfg := newTestConfig(db)
+
+			req := httptest.NewRequest(http.MethodPost, "/api/refresh", nil)
+			if tc.authorization != "none" {
+				req.Header.Set("Authorization", "Bearer old-token")
+			}
+			rec := httptest.NewRecorder()
+			cfg.refreshHandler(rec, req)
+			if rec.Code != tc.wantStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tc.wantStatus, rec.Code, rec.Body.String())
+			}
+			if tc.wantFamily != (family == userID.String()) {
+				t.Fatalf("Expected family revoked to be %v, got %v", tc.wantFamily, family)
+			}
+
+			if tc.insertErr != nil || tc.lostRace {
+				if slices.Contains(db.calls, "COMMIT") || !slices.Contains(db.calls, "ROLLBACK") {
+					t.Fatalf("Expected the rotation to be rolled back, got %v", db.calls)
+				}
+			}
+			if tc.wantStatus != http.StatusOK {
+				return
+			}
+
+			var resp struct {
+				Token        string `json:"token"`
+				RefreshToken string `json:"refresh_token"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("Expected JSON body, got %v", err)
+			}
+			if resp.RefreshToken == "" || resp.RefreshToken == "old-token" {
+				t.Fatalf("Expected a new refresh token, got %q", resp.RefreshToken)
+			}
+			if replacedBy != resp.RefreshToken || created != resp.RefreshToken {
+				t.Fatalf("Expected the old token replaced by the stored new one, got %v and %v", replacedBy, created)
+			}
+			subject, err := auth.ValidateJWT(resp.Token, cfg.config.JWTSecret)
+			if err != nil || subject != userID {
+				t.Fatalf("Expected an access token for %v, got %v (%v)", userID, subject, err)
+			}
+			if !slices.Contains(db.calls, "COMMIT") {
+				t.Fatalf("Expected the rotation to be committed, got %v", db.calls)
+			}
+		})
+	}
+}
+
+func TestRevoke(t *testing.T) {
+	db := newFakeDB(t)
+	var revoked any
+	db.on("RevokeRefreshToken", func(args []driver.NamedValue) fakeResult {
+		revoked = args[0].Value
+		return fakeResult{}
+	})
+	cfg := newTestConfig(db)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/revoke", nil)
+	req.Header.Set("Authorization", "Bearer old-token")
+	rec := httptest.NewRecorder()
+	cfg.revokeHandler(rec, req)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("Expected 204, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if revoked != "old-token" {
+		t.Fatalf("Expected the presented token to be revoked, got %v", revoked)
+	}
+
+	rec = httptest.NewRecorder()
+	cfg.revokeHandler(rec, httptest.NewRequest(http.MethodPost, "/api/revoke", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected 401 without a token, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,21 @@
+-- name: LikeChirp :execrows
+INSERT INTO chirp_likes (user_id, chirp_id)
+  VALUES ($1, $2)
+  ON CONFLICT (user_id, chirp_id) DO NOTHING;
+
+-- name: UnlikeChirp :execrows
+DELETE FROM chirp_likes
+  WHERE user_id = $1 AND chirp_id = $2;
+
+-- name: ListLikedChirpIDs :many
+SELECT chirp_id FROM chirp_likes
+  WHERE user_id = sqlc.arg('user_id') AND chirp_id = ANY(sqlc.arg('chirp_ids')::uuid[]);
+
+-- name: ListUserLikes :many
+SELECT chirps.*, chirp_likes.created_at AS liked_at FROM chirp_likes
+  JOIN chirps ON chirps.id = chirp_likes.chirp_id
+  WHERE chirp_likes.user_id = sqlc.arg('user_id')
+    AND (sqlc.narg('cursor_liked_at')::timestamp IS NULL
+      OR (chirp_likes.created_at, chirp_likes.chirp_id) < (sqlc.narg('cursor_liked_at'), sqlc.narg('cursor_chirp_id')::uuid))
+  ORDER BY chirp_likes.created_at DESC, chirp_likes.chirp_id DESC
+  LIMIT sqlc.arg('row_limit');
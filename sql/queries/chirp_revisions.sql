@@ -0,0 +1,14 @@
+-- name: UpdateChirpBody :one
+UPDATE chirps
+  SET body = $2, updated_at = NOW(), revision_count = revision_count + 1
+  WHERE id = $1 AND revision_count = sqlc.arg('expected_revision_count')
+  RETURNING *;
+
+-- name: CreateChirpRevision :exec
+INSERT INTO chirp_revisions (chirp_id, revision, body, created_at)
+  VALUES ($1, $2, $3, $4);
+
+-- name: ListChirpRevisions :many
+SELECT * FROM chirp_revisions
+  WHERE chirp_id = $1
+  ORDER BY revision ASC;
@@ -0,0 +1,31 @@
+-- name: ListChirpAncestors :many
+WITH RECURSIVE ancestors AS (
+    SELECT parent.*, 1 AS distance FROM chirps parent
+      WHERE parent.id = (SELECT child.in_reply_to FROM chirps child WHERE child.id = $1)
+  UNION ALL
+    SELECT parent.*, ancestors.distance + 1 FROM chirps parent
+      JOIN ancestors ON parent.id = ancestors.in_reply_to
+)
+SELECT id, created_at, updated_at, body, user_id, revision_count, in_reply_to, reply_count, like_count
+  FROM ancestors
+  ORDER BY distance DESC;
+
+-- name: ListChirpDescendants :many
+-- path sorts the tree depth first, each reply right after its parent and
+-- siblings oldest first; it doubles as the pagination cursor.
+WITH RECURSIVE descendants AS (
+    SELECT reply.*, 1 AS depth,
+        to_char(reply.created_at, 'YYYYMMDDHH24MISSUS') || reply.id::text AS path
+      FROM chirps reply
+      WHERE reply.in_reply_to = sqlc.arg('root_id')
+  UNION ALL
+    SELECT reply.*, descendants.depth + 1,
+        descendants.path || '/' || to_char(reply.created_at, 'YYYYMMDDHH24MISSUS') || reply.id::text
+      FROM chirps reply
+      JOIN descendants ON reply.in_reply_to = descendants.id
+)
+SELECT id, created_at, updated_at, body, user_id, revision_count, in_reply_to, reply_count, like_count, depth, path::text
+  FROM descendants
+  WHERE sqlc.narg('after_path')::text IS NULL OR path COLLATE "C" > sqlc.narg('after_path')
+  ORDER BY path COLLATE "C"
+  LIMIT sqlc.arg('row_limit');
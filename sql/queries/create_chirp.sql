@@ -1,5 +1,5 @@
 -- name: CreateChirp :one
 INSERT INTO chirps (
-  id, body, user_id
-) VALUES (gen_random_uuid(), $1, $2)
+  id, body, user_id, in_reply_to
+) VALUES (gen_random_uuid(), $1, $2, $3)
   RETURNING *;
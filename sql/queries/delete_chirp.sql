@@ -0,0 +1,3 @@
+-- name: DeleteChirp :exec
+DELETE FROM chirps
+  WHERE id = $1;
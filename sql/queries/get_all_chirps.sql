@@ -1,3 +0,0 @@
--- name: GetAllChirps :many
-SELECT * FROM chirps
-  ORDER BY updated_at;
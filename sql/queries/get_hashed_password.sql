@@ -1,3 +0,0 @@
--- name: GetHashedPasswordByUser :one
-SELECT hashed_password FROM users 
-  WHERE ($1 = email);
@@ -0,0 +1,19 @@
+-- name: ListChirpsAsc :many
+SELECT * FROM chirps
+  WHERE (sqlc.narg('author_id')::uuid IS NULL OR user_id = sqlc.narg('author_id'))
+    AND (sqlc.narg('since')::timestamp IS NULL OR created_at >= sqlc.narg('since'))
+    AND (sqlc.narg('until')::timestamp IS NULL OR created_at < sqlc.narg('until'))
+    AND (sqlc.narg('cursor_created_at')::timestamp IS NULL
+      OR (created_at, id) > (sqlc.narg('cursor_created_at'), sqlc.narg('cursor_id')::uuid))
+  ORDER BY created_at ASC, id ASC
+  LIMIT sqlc.arg('row_limit');
+
+-- name: ListChirpsDesc :many
+SELECT * FROM chirps
+  WHERE (sqlc.narg('author_id')::uuid IS NULL OR user_id = sqlc.narg('author_id'))
+    AND (sqlc.narg('since')::timestamp IS NULL OR created_at >= sqlc.narg('since'))
+    AND (sqlc.narg('until')::timestamp IS NULL OR created_at < sqlc.narg('until'))
+    AND (sqlc.narg('cursor_created_at')::timestamp IS NULL
+      OR (created_at, id) < (sqlc.narg('cursor_created_at'), sqlc.narg('cursor_id')::uuid))
+  ORDER BY created_at DESC, id DESC
+  LIMIT sqlc.arg('row_limit');
@@ -0,0 +1,18 @@
+-- name: GetLoginFailure :one
+SELECT * FROM login_failures
+  WHERE key = $1;
+
+-- name: RecordLoginFailure :one
+INSERT INTO login_failures (key, failures, last_failed_at)
+  VALUES (sqlc.arg(key), 1, sqlc.arg(failed_at))
+  ON CONFLICT (key) DO UPDATE
+  SET failures = CASE
+      WHEN login_failures.last_failed_at < sqlc.arg(window_start) THEN 1
+      ELSE login_failures.failures + 1
+    END,
+    last_failed_at = sqlc.arg(failed_at)
+  RETURNING *;
+
+-- name: ClearLoginFailures :exec
+DELETE FROM login_failures
+  WHERE key = $1;
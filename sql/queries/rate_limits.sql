@@ -0,0 +1,15 @@
+-- name: TakeRateLimitToken :one
+INSERT INTO rate_limit_buckets (key, tat)
+  VALUES (sqlc.arg(key), sqlc.arg(now)::bigint + sqlc.arg(emission_interval)::bigint)
+  ON CONFLICT (key) DO UPDATE
+  SET tat = GREATEST(rate_limit_buckets.tat, sqlc.arg(now)::bigint) + sqlc.arg(emission_interval)::bigint
+  WHERE GREATEST(rate_limit_buckets.tat, sqlc.arg(now)::bigint) - sqlc.arg(now)::bigint <= sqlc.arg(tolerance)::bigint
+  RETURNING tat;
+
+-- name: GetRateLimitTAT :one
+SELECT tat FROM rate_limit_buckets
+  WHERE key = $1;
+
+-- name: DeleteExpiredRateLimits :execrows
+DELETE FROM rate_limit_buckets
+  WHERE tat < $1;
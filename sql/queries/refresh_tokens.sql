@@ -0,0 +1,24 @@
+-- name: CreateRefreshToken :one
+INSERT INTO refresh_tokens (
+  token, user_id, expires_at
+) VALUES ($1, $2, $3)
+  RETURNING *;
+
+-- name: GetRefreshToken :one
+SELECT * FROM refresh_tokens
+  WHERE token = $1;
+
+-- name: RotateRefreshToken :execrows
+UPDATE refresh_tokens
+  SET revoked_at = NOW(), updated_at = NOW(), replaced_by = $2
+  WHERE token = $1 AND revoked_at IS NULL;
+
+-- name: RevokeRefreshToken :exec
+UPDATE refresh_tokens
+  SET revoked_at = NOW(), updated_at = NOW()
+  WHERE token = $1 AND revoked_at IS NULL;
+
+-- name: RevokeAllRefreshTokensForUser :exec
+UPDATE refresh_tokens
+  SET revoked_at = NOW(), updated_at = NOW()
+  WHERE user_id = $1 AND revoked_at IS NULL;
@@ -8,3 +8,32 @@ VALUES (
     $2
 )
 RETURNING *;
+
+-- name: GetUserByID :one
+SELECT * FROM users
+  WHERE id = $1;
+
+-- name: UpdateUser :one
+UPDATE users
+  SET email = $2, hashed_password = $3, updated_at = NOW(),
+    verified_at = CASE WHEN email = $2 THEN verified_at END
+  WHERE id = $1
+  RETURNING *;
+
+-- name: MarkUserVerified :one
+UPDATE users
+  SET verified_at = COALESCE(verified_at, NOW()), updated_at = NOW()
+  WHERE id = $1 AND email = $2
+  RETURNING *;
+
+-- name: UpdateUserPassword :one
+UPDATE users
+  SET hashed_password = $2, updated_at = NOW()
+  WHERE id = $1
+  RETURNING *;
+
+-- name: UpgradeUserToChirpyRed :one
+UPDATE users
+  SET is_chirpy_red = TRUE, updated_at = NOW()
+  WHERE id = $1
+  RETURNING *;
@@ -0,0 +1,7 @@
+-- +goose Up
+ALTER TABLE refresh_tokens ADD COLUMN revoked_at TIMESTAMP;
+ALTER TABLE refresh_tokens ADD COLUMN replaced_by TEXT;
+
+-- +goose Down
+ALTER TABLE refresh_tokens DROP COLUMN replaced_by;
+ALTER TABLE refresh_tokens DROP COLUMN revoked_at;
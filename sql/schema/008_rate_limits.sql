@@ -0,0 +1,9 @@
+-- +goose Up
+CREATE TABLE rate_limit_buckets (
+  key TEXT PRIMARY KEY,
+  -- theoretical arrival time of the next request, in Unix nanoseconds
+  tat BIGINT NOT NULL
+);
+
+-- +goose Down
+DROP TABLE rate_limit_buckets;
@@ -0,0 +1,10 @@
+-- +goose Up
+CREATE TABLE login_failures (
+    -- "account:<email>" or "ip:<address>"
+    key TEXT PRIMARY KEY,
+    failures INTEGER NOT NULL,
+    last_failed_at TIMESTAMP NOT NULL
+);
+
+-- +goose Down
+DROP TABLE login_failures;
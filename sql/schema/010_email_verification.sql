@@ -0,0 +1,19 @@
+-- +goose Up
+-- Fails if two accounts differ only by case; merge them before migrating
+CREATE UNIQUE INDEX users_email_lower_idx ON users (LOWER(email));
+UPDATE users SET email = LOWER(email) WHERE email <> LOWER(email);
+
+ALTER TABLE users ADD COLUMN verified_at TIMESTAMP;
+
+CREATE TABLE email_verification_tokens (
+    token_hash TEXT PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP
+);
+
+-- +goose Down
+DROP TABLE email_verification_tokens;
+ALTER TABLE users DROP COLUMN verified_at;
+DROP INDEX users_email_lower_idx;
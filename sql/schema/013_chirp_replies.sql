@@ -0,0 +1,31 @@
+-- +goose Up
+ALTER TABLE chirps
+    ADD COLUMN in_reply_to UUID REFERENCES chirps (id) ON DELETE SET NULL,
+    ADD COLUMN reply_count INTEGER NOT NULL DEFAULT 0;
+
+CREATE INDEX chirps_in_reply_to_idx ON chirps (in_reply_to);
+
+-- Keep reply_count right however a reply goes away, including when its
+-- author is deleted and their chirps cascade
+-- +goose StatementBegin
+CREATE FUNCTION chirps_count_replies() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP IN ('INSERT', 'UPDATE') AND NEW.in_reply_to IS NOT NULL THEN
+        UPDATE chirps SET reply_count = reply_count + 1 WHERE id = NEW.in_reply_to;
+    END IF;
+    IF TG_OP IN ('DELETE', 'UPDATE') AND OLD.in_reply_to IS NOT NULL THEN
+        UPDATE chirps SET reply_count = reply_count - 1 WHERE id = OLD.in_reply_to;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+CREATE TRIGGER chirps_count_replies
+    AFTER INSERT OR DELETE OR UPDATE OF in_reply_to ON chirps
+    FOR EACH ROW EXECUTE FUNCTION chirps_count_replies();
+
+-- +goose Down
+DROP TRIGGER chirps_count_replies ON chirps;
+DROP FUNCTION chirps_count_replies();
+ALTER TABLE chirps DROP COLUMN reply_count, DROP COLUMN in_reply_to;
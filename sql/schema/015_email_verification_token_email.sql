@@ -0,0 +1,8 @@
+-- +goose Up
+-- Existing tokens can't be tied to the address they were mailed to, so they
+-- are dropped; users ask for a new one from POST /api/users/verify/resend
+DELETE FROM email_verification_tokens;
+ALTER TABLE email_verification_tokens ADD COLUMN email TEXT NOT NULL;
+
+-- +goose Down
+ALTER TABLE email_verification_tokens DROP COLUMN email;
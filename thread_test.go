@@ -0,0 +1,177 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/YoavIsaacs/chirpy/internal/database"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+func TestReplyToChirp(t *testing.T) {
+	userID := uuid.New()
+	parentID := uuid.New()
+
+	tests := []struct {
+		name       string
+		payload    string
+		parentGone bool
+		wantStatus int
+		wantParent any
+	}{
+		{name: "Top-level chirp", payload: `{"body":"hello"}`, wantStatus: http.StatusCreated},
+		{name: "Explicit null", payload: `{"body":"hello","in_reply_to":null}`, wantStatus: http.StatusCreated},
+		{name: "Reply", payload: `{"body":"hello","in_reply_to":"` + parentID.String() + `"}`, wantStatus: http.StatusCreated, wantParent: parentID.String()},
+		{name: "Missing parent", payload: `{"body":"hello","in_reply_to":"` + parentID.String() + `"}`, parentGone: true, wantStatus: http.StatusUnprocessableEntity, wantParent: parentID.String()},
+		{name: "Malformed parent ID", payload: `{"body":"hello","in_reply_to":"nope"}`, wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			db := newFakeDB(t)
+			var gotParent any
+			db.on("CreateChirp", func(args []driver.NamedValue) fakeResult {
+				gotParent = args[2].Value
+				if tc.parentGone {
+					return fakeResult{Err: &pq.Error{Code: "23503"}}
+				}
+				chirp := database.Chirp{ID: uuid.New(), Body: args[0].Value.(string), UserID: userID}
+				if gotParent != nil {
+					chirp.InReplyTo = uuid.NullUUID{UUID: parentID, Valid: true}
+				}
+				return chirpRow(chirp)(args)
+			})
+			cfg := newTestConfig(db)
+
+			req := httptest.NewRequest(http.MethodPost, "/api/chirps", strings.NewReader(tc.payload))
+			rec := serveAuthenticated(t, cfg, cfg.addChirpsHandler, userID, req)
+			if rec.Code != tc.wantStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tc.wantStatus, rec.Code, rec.Body.String())
+			}
+			if gotParent != tc.wantParent {
+				t.Fatalf("Expected in_reply_to %v to be stored, got %v", tc.wantParent, gotParent)
+			}
+			if tc.wantStatus != http.StatusCreated {
+				return
+			}
+			resp := chirpResponse{}
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("Expected JSON body, got %v", err)
+			}
+			if (resp.InReplyTo != nil) != (tc.wantParent != nil) {
+				t.Fatalf("Expected in_reply_to in response to match, got %v", resp.InReplyTo)
+			}
+		})
+	}
+}
+
+func TestChirpThread(t *testing.T) {
+	postedAt := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	root := database.Chirp{ID: uuid.New(), CreatedAt: postedAt, Body: "root", UserID: uuid.New(), ReplyCount: 1}
+	parent := database.Chirp{ID: uuid.New(), CreatedAt: postedAt.Add(time.Minute), Body: "parent", UserID: uuid.New(), InReplyTo: uuid.NullUUID{UUID: root.ID, Valid: true}, ReplyCount: 1}
+	chirp := database.Chirp{ID: uuid.New(), CreatedAt: postedAt.Add(2 * time.Minute), Body: "chirp", UserID: uuid.New(), InReplyTo: uuid.NullUUID{UUID: parent.ID, Valid: true}, ReplyCount: 2}
+
+	replyColumns := append(append([]string{}, chirpColumns...), "depth", "path")
+	reply := func(body string, depth int64, inReplyTo uuid.UUID, path string) []driver.Value {
+		return []driver.Value{uuid.NewString(), postedAt, postedAt, body, uuid.NewString(), int64(0), inReplyTo.String(), int64(0), int64(0), depth, path}
+	}
+	firstID := uuid.New()
+
+	db := newFakeDB(t)
+	db.on("GetSingleChirp", func(args []driver.NamedValue) fakeResult {
+		if args[0].Value != chirp.ID.String() {
+			return fakeResult{Columns: chirpColumns}
+		}
+		return chirpRow(chirp)(args)
+	})
+	db.on("ListChirpAncestors", func(args []driver.NamedValue) fakeResult {
+		ancestors := fakeResult{Columns: chirpColumns}
+		for _, c := range []database.Chirp{root, parent} {
+			ancestors.Rows = append(ancestors.Rows, chirpRow(c)(args).Rows[0])
+		}
+		return ancestors
+	})
+	var afterPaths []any
+	db.on("ListChirpDescendants", func(args []driver.NamedValue) fakeResult {
+		afterPaths = append(afterPaths, args[1].Value)
+		if args[2].Value != int64(3) {
+			t.Errorf("Expected one more row than the page size to be fetched, got %v", args[2].Value)
+		}
+		return fakeResult{
+			Columns: replyColumns,
+			Rows: [][]driver.Value{
+				reply("first", 1, chirp.ID, "a"),
+				reply("nested", 2, firstID, "a/b"),
+				reply("second", 1, chirp.ID, "c"),
+			},
+		}
+	})
+	cfg := newTestConfig(db)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/chirps/{chirpID}/thread", cfg.getChirpThreadHandler)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/chirps/"+chirp.ID.String()+"/thread?limit=2", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp struct {
+		Ancestors []chirpResponse `json:"ancestors"`
+		Chirp     chirpResponse   `json:"chirp"`
+		Replies   []struct {
+			Body  string `json:"body"`
+			Depth int32  `json:"depth"`
+		} `json:"replies"`
+		NextCursor string `json:"next_cursor"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Expected JSON body, got %v", err)
+	}
+	if len(resp.Ancestors) != 2 || resp.Ancestors[0].Body != "root" || resp.Ancestors[1].Body != "parent" {
+		t.Fatalf("Expected ancestors root first, got %+v", resp.Ancestors)
+	}
+	if resp.Chirp.Body != "chirp" || resp.Chirp.ReplyCount != 2 || *resp.Chirp.InReplyTo != parent.ID {
+		t.Fatalf("Expected the requested chirp, got %+v", resp.Chirp)
+	}
+	if len(resp.Replies) != 2 || resp.Replies[0].Body != "first" || resp.Replies[1].Depth != 2 {
+		t.Fatalf("Expected the first page of replies in tree order, got %+v", resp.Replies)
+	}
+	if resp.NextCursor != base64.RawURLEncoding.EncodeToString([]byte("a/b")) {
+		t.Fatalf("Expected a cursor after the last reply, got %q", resp.NextCursor)
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/chirps/"+chirp.ID.String()+"/thread?limit=2&cursor="+resp.NextCursor, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if afterPaths[0] != nil || afterPaths[1] != "a/b" {
+		t.Fatalf("Expected the cursor to resume after path a/b, got %v", afterPaths)
+	}
+
+	for _, target := range []string{
+		"/api/chirps/" + chirp.ID.String() + "/thread?cursor=***",
+		"/api/chirps/" + chirp.ID.String() + "/thread?limit=0",
+		"/api/chirps/not-a-uuid/thread",
+	} {
+		rec = httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("Expected 400 for %s, got %d", target, rec.Code)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/chirps/"+uuid.NewString()+"/thread", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("Expected 404 for an unknown chirp, got %d", rec.Code)
+	}
+}
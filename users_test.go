@@ -0,0 +1,105 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/YoavIsaacs/chirpy/internal/auth"
+	"github.com/YoavIsaacs/chirpy/internal/database"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+func TestUpdateUser(t *testing.T) {
+	hashed, err := auth.HashPassword("say-my-name")
+	if err != nil {
+		t.Fatalf("Failed to hash password: %v", err)
+	}
+	createdAt := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	user := database.User{ID: uuid.New(), CreatedAt: createdAt, UpdatedAt: createdAt, Email: "walt@example.com", HashedPassword: hashed}
+
+	tests := []struct {
+		name       string
+		body       string
+		taken      bool
+		wantStatus int
+	}{
+		{name: "Empty body", body: "", wantStatus: http.StatusBadRequest},
+		{name: "No fields", body: `{"current_password":"say-my-name"}`, wantStatus: http.StatusBadRequest},
+		{name: "Missing current password", body: `{"password":"heisenberg"}`, wantStatus: http.StatusUnauthorized},
+		{name: "Wrong current password", body: `{"email":"heisenberg@example.com","current_password":"nope"}`, wantStatus: http.StatusUnauthorized},
+		{name: "Email taken", body: `{"email":"jesse@example.com","current_password":"say-my-name"}`, taken: true, wantStatus: http.StatusConflict},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			db := newFakeDB(t)
+			db.on("GetUserByID", userRow(user))
+			db.on("UpdateUser", func([]driver.NamedValue) fakeResult {
+				if tc.taken {
+					return fakeResult{Err: &pq.Error{Code: "23505"}}
+				}
+				t.Error("Expected the user not to be updated")
+				return fakeResult{Columns: userColumns}
+			})
+			cfg := newTestConfig(db)
+
+			req := httptest.NewRequest(http.MethodPut, "/api/users", strings.NewReader(tc.body))
+			rec := serveAuthenticated(t, cfg, cfg.updateUserHandler, user.ID, req)
+			if rec.Code != tc.wantStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tc.wantStatus, rec.Code, rec.Body.String())
+			}
+		})
+	}
+
+	t.Run("Password change", func(t *testing.T) {
+		db := newFakeDB(t)
+		db.on("GetUserByID", userRow(user))
+		var stored database.User
+		db.on("UpdateUser", func(args []driver.NamedValue) fakeResult {
+			stored = user
+			stored.Email = args[1].Value.(string)
+			stored.HashedPassword = args[2].Value.(string)
+			// The query sets updated_at = NOW()
+			stored.UpdatedAt = time.Now().UTC()
+			return userRow(stored)(args)
+		})
+		db.on("RevokeAllRefreshTokensForUser", func([]driver.NamedValue) fakeResult {
+			return fakeResult{}
+		})
+		cfg := newTestConfig(db)
+
+		req := httptest.NewRequest(http.MethodPut, "/api/users", strings.NewReader(`{"password":"heisenberg","current_password":"say-my-name"}`))
+		rec := serveAuthenticated(t, cfg, cfg.updateUserHandler, user.ID, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+
+		if stored.Email != user.Email {
+			t.Fatalf("Expected the email to be kept, got %q", stored.Email)
+		}
+		if !strings.HasPrefix(stored.HashedPassword, "$2a$") || stored.HashedPassword == hashed {
+			t.Fatalf("Expected a new bcrypt hash, got %q", stored.HashedPassword)
+		}
+		if err := auth.CheckPassword(stored.HashedPassword, "heisenberg"); err != nil {
+			t.Fatalf("Expected the new password to match the stored hash, got %v", err)
+		}
+
+		resp := userResponse{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("Expected JSON body, got %v", err)
+		}
+		if !resp.Updated_at.After(user.UpdatedAt) || !resp.Created_at.Equal(user.CreatedAt) {
+			t.Fatalf("Expected updated_at to move and created_at to stay, got %+v", resp)
+		}
+		if !slices.Contains(db.calls, "RevokeAllRefreshTokensForUser") {
+			t.Fatal("Expected refresh tokens to be revoked after a password change")
+		}
+	})
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/YoavIsaacs/chirpy/internal/auth"
+	"github.com/YoavIsaacs/chirpy/internal/database"
+	"github.com/YoavIsaacs/chirpy/internal/email"
+	"github.com/google/uuid"
+)
+
+// fakeMailer records messages instead of sending them.
+type fakeMailer struct {
+	mu   sync.Mutex
+	sent []email.Message
+	err  error
+}
+
+func (m *fakeMailer) Send(_ context.Context, msg email.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return m.err
+	}
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+var mailedTokenPattern = regexp.MustCompile(`[0-9a-f]{64}`)
+
+func (m *fakeMailer) lastToken(t *testing.T) string {
+	t.Helper()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.sent) == 0 {
+		t.Fatal("Expected an email to be sent")
+	}
+	token := mailedTokenPattern.FindString(m.sent[len(m.sent)-1].Body)
+	if token == "" {
+		t.Fatalf("Expected a token in the email body, got %q", m.sent[len(m.sent)-1].Body)
+	}
+	return token
+}
+
+func TestSignupSendsVerificationEmail(t *testing.T) {
+	db := newFakeDB(t)
+	var createdEmail string
+	db.on("CreateUser", func(args []driver.NamedValue) fakeResult {
+		createdEmail = args[0].Value.(string)
+		return userRow(database.User{ID: uuid.New(), Email: createdEmail, HashedPassword: args[1].Value.(string)})(args)
+	})
+	var storedHash, tokenEmail string
+	var expiresAt time.Time
+	db.on("CreateEmailVerificationToken", func(args []driver.NamedValue) fakeResult {
+		storedHash = args[0].Value.(string)
+		tokenEmail = args[2].Value.(string)
+		expiresAt = args[3].Value.(time.Time)
+		return fakeResult{}
+	})
+	cfg := newTestConfig(db)
+	mailer := cfg.mailer.(*fakeMailer)
+
+	rec := httptest.NewRecorder()
+	cfg.addUserHandler(rec, httptest.NewRequest(http.MethodPost, "/api/users", strings.NewReader(`{"email":" Walt@BreakingBad.com ","password":"04234"}`)))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	if createdEmail != "walt@breakingbad.com" {
+		t.Fatalf("Expected normalized email to be stored, got '%s'", createdEmail)
+	}
+	resp := userResponse{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Expected JSON body, got %v", err)
+	}
+	if resp.EmailVerified {
+		t.Fatal("Expected a new account to be unverified")
+	}
+
+	if mailer.sent[0].To != "walt@breakingbad.com" {
+		t.Fatalf("Expected email to the normalized address, got '%s'", mailer.sent[0].To)
+	}
+	token := mailer.lastToken(t)
+	if storedHash != auth.HashToken(token) {
+		t.Fatal("Expected only the hash of the mailed token to be stored")
+	}
+	if tokenEmail != "walt@breakingbad.com" {
+		t.Fatalf("Expected the token to be tied to the mailed address, got '%s'", tokenEmail)
+	}
+	if ttl := time.Until(expiresAt); ttl < 23*time.Hour || ttl > 24*time.Hour {
+		t.Fatalf("Expected token to expire in about 24h, got %v", ttl)
+	}
+}
+
+func TestSignupRejectsInvalidEmail(t *testing.T) {
+	cfg := newTestConfig(newFakeDB(t))
+
+	for _, address := range []string{"", "walt", "walt@localhost", "Walt <walt@example.com>"} {
+		rec := httptest.NewRecorder()
+		cfg.addUserHandler(rec, httptest.NewRequest(http.MethodPost, "/api/users", strings.NewReader(`{"email":"`+address+`","password":"04234"}`)))
+		if rec.Code != http.StatusUnprocessableEntity {
+			t.Fatalf("Expected 422 for %q, got %d", address, rec.Code)
+		}
+	}
+}
+
+func TestVerifyEmail(t *testing.T) {
+	user := database.User{ID: uuid.New(), Email: "walt@breakingbad.com"}
+	validToken := "a-valid-token"
+
+	db := newFakeDB(t)
+	db.on("ConsumeEmailVerificationToken", func(args []driver.NamedValue) fakeResult {
+		if args[0].Value != auth.HashToken(validToken) {
+			return fakeResult{Columns: []string{"user_id", "email"}}
+		}
+		return fakeResult{Columns: []string{"user_id", "email"}, Rows: [][]driver.Value{{user.ID.String(), user.Email}}}
+	})
+	db.on("MarkUserVerified", func(args []driver.NamedValue) fakeResult {
+		if args[1].Value != user.Email {
+			t.Errorf("Expected the token's address to be checked, got %v", args[1].Value)
+		}
+		verified := user
+		verified.VerifiedAt = sql.NullTime{Time: time.Now().UTC(), Valid: true}
+		return userRow(verified)(args)
+	})
+	cfg := newTestConfig(db)
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+	}{
+		{name: "Valid token", body: `{"token":"` + validToken + `"}`, wantStatus: http.StatusOK},
+		{name: "Unknown, used or expired token", body: `{"token":"something-else"}`, wantStatus: http.StatusBadRequest},
+		{name: "Missing token", body: `{}`, wantStatus: http.StatusBadRequest},
+		{name: "Invalid JSON", body: `{`, wantStatus: http.StatusBadRequest},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			cfg.verifyEmailHandler(rec, httptest.NewRequest(http.MethodPost, "/api/users/verify", strings.NewReader(tc.body)))
+			if rec.Code != tc.wantStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tc.wantStatus, rec.Code, rec.Body.String())
+			}
+			if tc.wantStatus == http.StatusOK && !strings.Contains(rec.Body.String(), `"email_verified":true`) {
+				t.Fatalf("Expected verified user in response, got %s", rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestChangingEmailRequiresVerification(t *testing.T) {
+	hashed, err := auth.HashPassword("04234")
+	if err != nil {
+		t.Fatalf("Failed to hash password: %v", err)
+	}
+	user := database.User{
+		ID:             uuid.New(),
+		Email:          "walt@breakingbad.com",
+		HashedPassword: hashed,
+		VerifiedAt:     sql.NullTime{Time: time.Now().UTC(), Valid: true},
+	}
+
+	db := newFakeDB(t)
+	db.on("GetUserByID", userRow(user))
+	db.on("UpdateUser", func(args []driver.NamedValue) fakeResult {
+		updated := user
+		updated.Email = args[1].Value.(string)
+		updated.VerifiedAt = sql.NullTime{}
+		return userRow(updated)(args)
+	})
+	db.on("CreateEmailVerificationToken", func([]driver.NamedValue) fakeResult { return fakeResult{} })
+	cfg := newTestConfig(db)
+	mailer := cfg.mailer.(*fakeMailer)
+
+	token, err := auth.MakeJWT(user.ID, cfg.config.JWTSecret, time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to create token: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodPut, "/api/users", strings.NewReader(`{"email":"Heisenberg@Example.com","current_password":"04234"}`))
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	cfg.middlewareAuthenticate(cfg.updateUserHandler)(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if !strings.Contains(rec.Body.String(), `"email":"heisenberg@example.com"`) || !strings.Contains(rec.Body.String(), `"email_verified":false`) {
+		t.Fatalf("Expected normalized, unverified email in response, got %s", rec.Body.String())
+	}
+	if len(mailer.sent) != 1 || mailer.sent[0].To != "heisenberg@example.com" {
+		t.Fatalf("Expected a verification email to the new address, got %+v", mailer.sent)
+	}
+}
+
+func TestVerifyEmailAfterAddressChange(t *testing.T) {
+	hashed, err := auth.HashPassword("04234")
+	if err != nil {
+		t.Fatalf("Failed to hash password: %v", err)
+	}
+	user := database.User{ID: uuid.New(), HashedPassword: hashed}
+
+	// Tokens and the current address live here so that each step sees what
+	// the previous one stored
+	type storedToken struct {
+		email string
+		used  bool
+	}
+	tokens := map[string]*storedToken{}
+	var verified bool
+
+	db := newFakeDB(t)
+	db.on("CreateUser", func(args []driver.NamedValue) fakeResult {
+		user.Email = args[0].Value.(string)
+		return userRow(user)(args)
+	})
+	db.on("GetUserByID", func(args []driver.NamedValue) fakeResult {
+		return userRow(user)(args)
+	})
+	db.on("UpdateUser", func(args []driver.NamedValue) fakeResult {
+		user.Email = args[1].Value.(string)
+		return userRow(user)(args)
+	})
+	db.on("CreateEmailVerificationToken", func(args []driver.NamedValue) fakeResult {
+		tokens[args[0].Value.(string)] = &storedToken{email: args[2].Value.(string)}
+		return fakeResult{}
+	})
+	db.on("ConsumeEmailVerificationToken", func(args []driver.NamedValue) fakeResult {
+		token, ok := tokens[args[0].Value.(string)]
+		if !ok || token.used {
+			return fakeResult{Columns: []string{"user_id", "email"}}
+		}
+		token.used = true
+		return fakeResult{Columns: []string{"user_id", "email"}, Rows: [][]driver.Value{{user.ID.String(), token.email}}}
+	})
+	db.on("MarkUserVerified", func(args []driver.NamedValue) fakeResult {
+		if args[1].Value != user.Email {
+			return fakeResult{Columns: userColumns}
+		}
+		verified = true
+		return userRow(user)(args)
+	})
+	cfg := newTestConfig(db)
+	mailer := cfg.mailer.(*fakeMailer)
+
+	rec := httptest.NewRecorder()
+	cfg.addUserHandler(rec, httptest.NewRequest(http.MethodPost, "/api/users", strings.NewReader(`{"email":"walt@example.com","password":"04234"}`)))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+	oldToken := mailer.lastToken(t)
+
+	req := httptest.NewRequest(http.MethodPut, "/api/users", strings.NewReader(`{"email":"heisenberg@example.com","current_password":"04234"}`))
+	rec = serveAuthenticated(t, cfg, cfg.updateUserHandler, user.ID, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	cfg.verifyEmailHandler(rec, httptest.NewRequest(http.MethodPost, "/api/users/verify", strings.NewReader(`{"token":"`+oldToken+`"}`)))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("Expected a token for the old address to be rejected, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if verified {
+		t.Fatal("Expected the new address to stay unverified")
+	}
+	if db.calls[len(db.calls)-1] != "ROLLBACK" {
+		t.Fatalf("Expected the rejected verification to be rolled back, got %v", db.calls)
+	}
+
+	rec = httptest.NewRecorder()
+	cfg.verifyEmailHandler(rec, httptest.NewRequest(http.MethodPost, "/api/users/verify", strings.NewReader(`{"token":"`+mailer.lastToken(t)+`"}`)))
+	if rec.Code != http.StatusOK || !verified {
+		t.Fatalf("Expected the token mailed to the new address to verify it, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestResendVerificationEmail(t *testing.T) {
+	unverified := database.User{ID: uuid.New(), Email: "jesse@example.com"}
+	verified := database.User{ID: uuid.New(), Email: "walt@breakingbad.com", VerifiedAt: sql.NullTime{Time: time.Now().UTC(), Valid: true}}
+
+	tests := []struct {
+		name       string
+		user       database.User
+		mailErr    error
+		wantStatus int
+		wantSent   int
+	}{
+		{name: "Unverified", user: unverified, wantStatus: http.StatusAccepted, wantSent: 1},
+		{name: "Already verified", user: verified, wantStatus: http.StatusConflict},
+		{name: "Mailer down", user: unverified, mailErr: errors.New("connection refused"), wantStatus: http.StatusInternalServerError},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			db := newFakeDB(t)
+			db.on("GetUserByID", userRow(tc.user))
+			var stored int
+			db.on("CreateEmailVerificationToken", func(args []driver.NamedValue) fakeResult {
+				if args[1].Value != tc.user.ID.String() {
+					t.Errorf("Expected a token for the caller, got %v", args[1].Value)
+				}
+				stored++
+				return fakeResult{}
+			})
+			cfg := newTestConfig(db)
+			mailer := cfg.mailer.(*fakeMailer)
+			mailer.err = tc.mailErr
+
+			req := httptest.NewRequest(http.MethodPost, "/api/users/verify/resend", nil)
+			rec := serveAuthenticated(t, cfg, cfg.resendVerificationHandler, tc.user.ID, req)
+			if rec.Code != tc.wantStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tc.wantStatus, rec.Code, rec.Body.String())
+			}
+			if len(mailer.sent) != tc.wantSent {
+				t.Fatalf("Expected %d emails, got %d", tc.wantSent, len(mailer.sent))
+			}
+			if tc.wantSent > 0 && (mailer.sent[0].To != tc.user.Email || stored != 1) {
+				t.Fatalf("Expected one new token mailed to %s, got %+v", tc.user.Email, mailer.sent)
+			}
+		})
+	}
+
+	t.Run("Anonymous", func(t *testing.T) {
+		cfg := newTestConfig(newFakeDB(t))
+		rec := httptest.NewRecorder()
+		cfg.middlewareAuthenticate(cfg.resendVerificationHandler)(rec, httptest.NewRequest(http.MethodPost, "/api/users/verify/resend", nil))
+		if rec.Code != http.StatusUnauthorized {
+			t.Fatalf("Expected 401, got %d", rec.Code)
+		}
+	})
+}
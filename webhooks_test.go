@@ -0,0 +1,95 @@
+package main
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/YoavIsaacs/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+// fakePolka plays the payment provider, delivering webhook events the way
+// Polka does: a JSON body authenticated with an ApiKey header.
+type fakePolka struct {
+	apiKey  string
+	handler http.HandlerFunc
+}
+
+func (p fakePolka) send(event string, userID uuid.UUID) *httptest.ResponseRecorder {
+	body := fmt.Sprintf(`{"event":%q,"data":{"user_id":%q}}`, event, userID)
+	req := httptest.NewRequest(http.MethodPost, "/api/polka/webhooks", strings.NewReader(body))
+	if p.apiKey != "" {
+		req.Header.Set("Authorization", "ApiKey "+p.apiKey)
+	}
+	rec := httptest.NewRecorder()
+	p.handler(rec, req)
+	return rec
+}
+
+func TestPolkaWebhook(t *testing.T) {
+	knownUser := database.User{
+		ID:          uuid.New(),
+		CreatedAt:   time.Now().UTC(),
+		UpdatedAt:   time.Now().UTC(),
+		Email:       "saul@bettercall.com",
+		IsChirpyRed: true,
+	}
+
+	db := newFakeDB(t)
+	upgraded := 0
+	db.on("UpgradeUserToChirpyRed", func(args []driver.NamedValue) fakeResult {
+		if args[0].Value != knownUser.ID.String() {
+			return fakeResult{Columns: userColumns}
+		}
+		upgraded++
+		return userRow(knownUser)(args)
+	})
+
+	cfg := newTestConfig(db)
+	cfg.config.PolkaKey = "f271c81ff7084ee5b99a5091b42d486e"
+
+	provider := fakePolka{apiKey: cfg.config.PolkaKey, handler: cfg.polkaWebhookHandler}
+
+	t.Run("Upgrades known user", func(t *testing.T) {
+		rec := provider.send("user.upgraded", knownUser.ID)
+		if rec.Code != http.StatusNoContent {
+			t.Fatalf("Expected status %d, got %d", http.StatusNoContent, rec.Code)
+		}
+		if upgraded != 1 {
+			t.Fatalf("Expected one upgrade, got %d", upgraded)
+		}
+	})
+
+	t.Run("Unknown user is 404", func(t *testing.T) {
+		rec := provider.send("user.upgraded", uuid.New())
+		if rec.Code != http.StatusNotFound {
+			t.Fatalf("Expected status %d, got %d", http.StatusNotFound, rec.Code)
+		}
+	})
+
+	t.Run("Other events are ignored", func(t *testing.T) {
+		before := upgraded
+		rec := provider.send("user.payment_failed", knownUser.ID)
+		if rec.Code != http.StatusNoContent {
+			t.Fatalf("Expected status %d, got %d", http.StatusNoContent, rec.Code)
+		}
+		if upgraded != before {
+			t.Fatal("Expected ignored event not to upgrade the user")
+		}
+	})
+
+	t.Run("Wrong or missing key is rejected", func(t *testing.T) {
+		for _, key := range []string{"", "not-the-key"} {
+			impostor := fakePolka{apiKey: key, handler: cfg.polkaWebhookHandler}
+			rec := impostor.send("user.upgraded", knownUser.ID)
+			if rec.Code != http.StatusUnauthorized {
+				t.Fatalf("Expected status %d for key '%s', got %d", http.StatusUnauthorized, key, rec.Code)
+			}
+		}
+	})
+}